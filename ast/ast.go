@@ -7,7 +7,16 @@ import (
 	"github.com/singurty/lox/token"
 )
 
+// Node is implemented by every expression and statement. Pos is the
+// position of the first character of the node and End the position just
+// after its last one.
+type Node interface {
+	Pos() token.Position
+	End() token.Position
+}
+
 type Expr interface {
+	Node
 	String() string
 }
 
@@ -29,6 +38,14 @@ func (t *Ternary) String() string {
 	return sb.String()
 }
 
+func (t *Ternary) Pos() token.Position {
+	return t.Condition.Pos()
+}
+
+func (t *Ternary) End() token.Position {
+	return t.Else.End()
+}
+
 type Binary struct {
 	Left Expr
 	Operator token.Token
@@ -48,9 +65,19 @@ func (b *Binary) String() string {
 	return sb.String()
 }
 
+func (b *Binary) Pos() token.Position {
+	return b.Left.Pos()
+}
+
+func (b *Binary) End() token.Position {
+	return b.Right.End()
+}
+
 // for parenthesized expressions
 type Grouping struct {
+	LeftParen token.Token
 	Expression Expr
+	RightParen token.Token
 }
 
 func (g *Grouping) String() string {
@@ -61,7 +88,18 @@ func (g *Grouping) String() string {
 	return sb.String()
 }
 
+func (g *Grouping) Pos() token.Position {
+	return g.LeftParen.Start
+}
+
+func (g *Grouping) End() token.Position {
+	return g.RightParen.End
+}
+
+// Token is the literal's token, it is zero for literals made up by the
+// parser
 type Literal struct {
+	Token token.Token
 	Value interface{}
 }
 
@@ -71,6 +109,14 @@ func (l *Literal) String() string {
 	return sb.String()
 }
 
+func (l *Literal) Pos() token.Position {
+	return l.Token.Start
+}
+
+func (l *Literal) End() token.Position {
+	return l.Token.End
+}
+
 type Unary struct {
 	Operator token.Token
 	Right Expr
@@ -83,6 +129,14 @@ func (u *Unary) String() string {
 	return sb.String()
 }
 
+func (u *Unary) Pos() token.Position {
+	return u.Operator.Start
+}
+
+func (u *Unary) End() token.Position {
+	return u.Right.End()
+}
+
 type Assign struct {
 	Name token.Token
 	Value Expr
@@ -96,6 +150,14 @@ func (a *Assign) String() string {
 	return sb.String()
 }
 
+func (a *Assign) Pos() token.Position {
+	return a.Name.Start
+}
+
+func (a *Assign) End() token.Position {
+	return a.Value.End()
+}
+
 type Get struct {
 	Name token.Token
 	Object Expr
@@ -105,6 +167,14 @@ func (g *Get) String() string {
 	return fmt.Sprintf("(get %v)", g.Name.Lexeme)
 }
 
+func (g *Get) Pos() token.Position {
+	return g.Object.Pos()
+}
+
+func (g *Get) End() token.Position {
+	return g.Name.End
+}
+
 type Set struct {
 	Name token.Token
 	Object Expr
@@ -115,24 +185,225 @@ func (s *Set) String() string {
 	return fmt.Sprintf("(set %v %v)", s.Name.Lexeme, s.Value)
 }
 
+func (s *Set) Pos() token.Position {
+	return s.Object.Pos()
+}
+
+func (s *Set) End() token.Position {
+	return s.Value.End()
+}
+
+// List is a list literal
+type List struct {
+	LeftBracket token.Token
+	Elements []Expr
+	RightBracket token.Token
+}
+
+func (l *List) String() string {
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i, element := range l.Elements {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(element.String())
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+func (l *List) Pos() token.Position {
+	return l.LeftBracket.Start
+}
+
+func (l *List) End() token.Position {
+	return l.RightBracket.End
+}
+
+// Interpolation is a string with embedded expressions such as "a${b}c".
+// Parts alternates string literals and expressions, starting and ending
+// with a literal.
+type Interpolation struct {
+	Parts []Expr
+}
+
+func (i *Interpolation) String() string {
+	var sb strings.Builder
+	sb.WriteString("(interpolate")
+	for _, part := range i.Parts {
+		sb.WriteString(" ")
+		sb.WriteString(part.String())
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func (i *Interpolation) Pos() token.Position {
+	return i.Parts[0].Pos()
+}
+
+func (i *Interpolation) End() token.Position {
+	return i.Parts[len(i.Parts)-1].End()
+}
+
+// Map is a map literal such as {"a": 1}. Keys and Values are parallel.
+type Map struct {
+	LeftBrace token.Token
+	Keys []Expr
+	Values []Expr
+	RightBrace token.Token
+}
+
+func (m *Map) String() string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, key := range m.Keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(key.String())
+		sb.WriteString(": ")
+		sb.WriteString(m.Values[i].String())
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+func (m *Map) Pos() token.Position {
+	return m.LeftBrace.Start
+}
+
+func (m *Map) End() token.Position {
+	return m.RightBrace.End
+}
+
+// Index is a subscript such as list[0] or map["key"]
+type Index struct {
+	Object Expr
+	Index Expr
+	RightBracket token.Token
+}
+
+func (i *Index) String() string {
+	return fmt.Sprintf("(index %v %v)", i.Object, i.Index)
+}
+
+func (i *Index) Pos() token.Position {
+	return i.Object.Pos()
+}
+
+func (i *Index) End() token.Position {
+	return i.RightBracket.End
+}
+
+// SetIndex is an assignment to a subscript such as list[0] = 1
+type SetIndex struct {
+	Object Expr
+	Index Expr
+	RightBracket token.Token
+	Value Expr
+}
+
+func (s *SetIndex) String() string {
+	return fmt.Sprintf("(set-index %v %v %v)", s.Object, s.Index, s.Value)
+}
+
+func (s *SetIndex) Pos() token.Position {
+	return s.Object.Pos()
+}
+
+func (s *SetIndex) End() token.Position {
+	return s.Value.End()
+}
+
 type Stmt interface {
+	Node
 }
 
+// Semicolon is zero when the statement is the trailing expression the REPL
+// prints
 type ExprStmt struct {
 	Expression Expr
+	Semicolon token.Token
+}
+
+func (e *ExprStmt) Pos() token.Position {
+	return e.Expression.Pos()
+}
+
+func (e *ExprStmt) End() token.Position {
+	return endOf(e.Semicolon, e.Expression)
 }
 
 type PrintStmt struct {
+	Keyword token.Token
 	Expression Expr
+	Semicolon token.Token
 }
 
+func (p *PrintStmt) Pos() token.Position {
+	if p.Keyword.Start.IsValid() {
+		return p.Keyword.Start
+	}
+	return p.Expression.Pos()
+}
+
+func (p *PrintStmt) End() token.Position {
+	return endOf(p.Semicolon, p.Expression)
+}
+
+// The braces are zero for blocks made up by the parser, such as the one
+// wrapping a for loop
 type Block struct {
+	LeftBrace token.Token
 	Statements []Stmt
+	RightBrace token.Token
+}
+
+func (b *Block) Pos() token.Position {
+	if !b.LeftBrace.Start.IsValid() && len(b.Statements) > 0 {
+		return b.Statements[0].Pos()
+	}
+	return b.LeftBrace.Start
+}
+
+func (b *Block) End() token.Position {
+	if !b.RightBrace.End.IsValid() && len(b.Statements) > 0 {
+		return b.Statements[len(b.Statements)-1].End()
+	}
+	return b.RightBrace.End
+}
+
+// Import binds the module loaded from Path to Name: import "path" as name;
+type Import struct {
+	Keyword token.Token
+	Path token.Token
+	Name token.Token
+	Semicolon token.Token
+}
+
+func (i *Import) Pos() token.Position {
+	return i.Keyword.Start
+}
+
+func (i *Import) End() token.Position {
+	return i.Semicolon.End
 }
 
 type Var struct {
+	Keyword token.Token
 	Name token.Token
 	Initializer Expr
+	Semicolon token.Token
+}
+
+func (v *Var) Pos() token.Position {
+	return v.Keyword.Start
+}
+
+func (v *Var) End() token.Position {
+	return v.Semicolon.End
 }
 
 type Variable struct {
@@ -143,12 +414,70 @@ func (v *Variable) String() string {
 	return v.Name.Lexeme
 }
 
+func (v *Variable) Pos() token.Position {
+	return v.Name.Start
+}
+
+func (v *Variable) End() token.Position {
+	return v.Name.End
+}
+
 type If struct {
+	Keyword token.Token
 	Condition Expr
 	ThenBranch Stmt
 	ElseBranch Stmt
 }
 
+func (i *If) Pos() token.Position {
+	return i.Keyword.Start
+}
+
+func (i *If) End() token.Position {
+	if i.ElseBranch != nil {
+		return i.ElseBranch.End()
+	}
+	return i.ThenBranch.End()
+}
+
+// Update is a compound assignment such as a += 1 or an increment such as
+// a++. Target is a *Variable, *Get or *Index whose object and index are
+// evaluated only once. Value is nil for ++ and --.
+type Update struct {
+	Target Expr
+	Operator token.Token
+	Value Expr
+	// Prefix increments evaluate to the new value, postfix ones to the old
+	Prefix bool
+}
+
+func (u *Update) String() string {
+	if u.Value == nil {
+		if u.Prefix {
+			return fmt.Sprintf("(%v %v)", u.Operator.Lexeme, u.Target)
+		}
+		return fmt.Sprintf("(%v %v)", u.Target, u.Operator.Lexeme)
+	}
+	return fmt.Sprintf("(%v %v %v)", u.Operator.Lexeme, u.Target, u.Value)
+}
+
+func (u *Update) Pos() token.Position {
+	if u.Prefix {
+		return u.Operator.Start
+	}
+	return u.Target.Pos()
+}
+
+func (u *Update) End() token.Position {
+	if u.Value != nil {
+		return u.Value.End()
+	}
+	if u.Prefix {
+		return u.Target.End()
+	}
+	return u.Operator.End
+}
+
 type Logical struct {
 	Left Expr
 	Operator token.Token
@@ -163,9 +492,21 @@ func (l *Logical) String() string {
 	return sb.String()
 }
 
+func (l *Logical) Pos() token.Position {
+	return l.Left.Pos()
+}
+
+func (l *Logical) End() token.Position {
+	return l.Right.End()
+}
+
 type Lambda struct {
+	Keyword token.Token
 	Parameters []token.Token
 	Body []Stmt
+	RightBrace token.Token
+	// Generator lambdas contain yield
+	Generator bool
 }
 
 func (l *Lambda) String() string {
@@ -174,6 +515,56 @@ func (l *Lambda) String() string {
 	return sb.String()
 }
 
+func (l *Lambda) Pos() token.Position {
+	return l.Keyword.Start
+}
+
+func (l *Lambda) End() token.Position {
+	return l.RightBrace.End
+}
+
+// Yield suspends the generator running it. Value is nil for a bare yield.
+type Yield struct {
+	Keyword token.Token
+	Value Expr
+}
+
+func (y *Yield) String() string {
+	if y.Value == nil {
+		return "(yield)"
+	}
+	return fmt.Sprintf("(yield %v)", y.Value)
+}
+
+func (y *Yield) Pos() token.Position {
+	return y.Keyword.Start
+}
+
+func (y *Yield) End() token.Position {
+	if y.Value != nil {
+		return y.Value.End()
+	}
+	return y.Keyword.End
+}
+
+// Spawn runs Call in a new task and evaluates to the task
+type Spawn struct {
+	Keyword token.Token
+	Call *Call
+}
+
+func (s *Spawn) String() string {
+	return fmt.Sprintf("(spawn %v)", s.Call)
+}
+
+func (s *Spawn) Pos() token.Position {
+	return s.Keyword.Start
+}
+
+func (s *Spawn) End() token.Position {
+	return s.Call.End()
+}
+
 type This struct {
 	Keyword token.Token
 }
@@ -182,6 +573,14 @@ func (t *This) String() string {
 	return "<this>"
 }
 
+func (t *This) Pos() token.Position {
+	return t.Keyword.Start
+}
+
+func (t *This) End() token.Position {
+	return t.Keyword.End
+}
+
 type Super struct {
 	Keyword token.Token
 	Method token.Token
@@ -191,23 +590,86 @@ func (s *Super) String() string {
 	return "<super." + s.Method.Lexeme + ">"
 }
 
+func (s *Super) Pos() token.Position {
+	return s.Keyword.Start
+}
+
+func (s *Super) End() token.Position {
+	return s.Method.End
+}
+
 type While struct {
+	Keyword token.Token
 	Condition Expr
 	Body Stmt
 }
 
+func (w *While) Pos() token.Position {
+	return w.Keyword.Start
+}
+
+func (w *While) End() token.Position {
+	return w.Body.End()
+}
+
+// ForIn runs Body once for each value Iterable produces, with Name bound to
+// the value in a new scope
+type ForIn struct {
+	Keyword token.Token
+	Name token.Token
+	Iterable Expr
+	Body Stmt
+}
+
+func (f *ForIn) Pos() token.Position {
+	return f.Keyword.Start
+}
+
+func (f *ForIn) End() token.Position {
+	return f.Body.End()
+}
+
 // keep track of increment expression because it should be executed even when continuing
 type For struct {
+	Keyword token.Token
 	Body Stmt
 	Condition Expr
 	Increment Expr
 	Initializer Stmt
 }
 
+func (f *For) Pos() token.Position {
+	return f.Keyword.Start
+}
+
+func (f *For) End() token.Position {
+	return f.Body.End()
+}
+
 type Break struct {
+	Keyword token.Token
+	Semicolon token.Token
+}
+
+func (b *Break) Pos() token.Position {
+	return b.Keyword.Start
+}
+
+func (b *Break) End() token.Position {
+	return b.Semicolon.End
 }
 
 type Continue struct {
+	Keyword token.Token
+	Semicolon token.Token
+}
+
+func (c *Continue) Pos() token.Position {
+	return c.Keyword.Start
+}
+
+func (c *Continue) End() token.Position {
+	return c.Semicolon.End
 }
 
 type Call struct {
@@ -227,19 +689,114 @@ func (c *Call) String() string {
 	return sb.String()
 }
 
+func (c *Call) Pos() token.Position {
+	return c.Callee.Pos()
+}
+
+func (c *Call) End() token.Position {
+	return c.Paren.End
+}
+
+// Keyword is zero for methods, which are declared without "fun"
 type Function struct {
+	Keyword token.Token
 	Name token.Token
 	Parameters []token.Token
 	Body []Stmt
+	RightBrace token.Token
+	// Getter methods have no parameter list and run when the property is
+	// read
+	Getter bool
+	// Setter methods are declared with "set" and run when the property is
+	// assigned
+	Setter bool
+	// Generator functions contain yield, calling them returns a generator
+	Generator bool
+}
+
+func (f *Function) Pos() token.Position {
+	if f.Keyword.Start.IsValid() {
+		return f.Keyword.Start
+	}
+	return f.Name.Start
+}
+
+func (f *Function) End() token.Position {
+	return f.RightBrace.End
 }
 
 type Return struct {
 	Keyword token.Token
 	Value Expr
+	Semicolon token.Token
+}
+
+func (r *Return) Pos() token.Position {
+	return r.Keyword.Start
+}
+
+func (r *Return) End() token.Position {
+	return r.Semicolon.End
+}
+
+type Throw struct {
+	Keyword token.Token
+	Value Expr
+	Semicolon token.Token
+}
+
+func (t *Throw) Pos() token.Position {
+	return t.Keyword.Start
+}
+
+func (t *Throw) End() token.Position {
+	return t.Semicolon.End
+}
+
+// Try runs Body and, when it throws, Catch with the error bound to Name.
+// Finally always runs last. Catch or Finally may be nil but not both.
+type Try struct {
+	Keyword token.Token
+	Body *Block
+	Name token.Token
+	Catch *Block
+	Finally *Block
+}
+
+func (t *Try) Pos() token.Position {
+	return t.Keyword.Start
+}
+
+func (t *Try) End() token.Position {
+	if t.Finally != nil {
+		return t.Finally.End()
+	}
+	return t.Catch.End()
 }
 
 type Class struct {
+	Keyword token.Token
 	Name token.Token
 	SuperClass *Variable
 	Methods []*Function
+	// ClassMethods are declared with "class" and called on the class itself
+	ClassMethods []*Function
+	RightBrace token.Token
+}
+
+func (c *Class) Pos() token.Position {
+	return c.Keyword.Start
+}
+
+func (c *Class) End() token.Position {
+	return c.RightBrace.End
+}
+
+// endOf is the end of a statement closed by semicolon, or the end of expr when
+// the semicolon was left out
+func endOf(semicolon token.Token, expr Expr) token.Position {
+	if semicolon.End.IsValid() {
+		return semicolon.End
+	}
+	return expr.End()
 }
@@ -0,0 +1,157 @@
+package diagnostic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Span is a range of source, End is just after its last character
+type Span struct {
+	Start token.Position `json:"start"`
+	End token.Position `json:"end"`
+}
+
+// TokenSpan is the span of tk
+func TokenSpan(tk token.Token) Span {
+	return Span{Start: tk.Start, End: tk.End}
+}
+
+// NodeSpan is the span of an expression or statement
+func NodeSpan(node ast.Node) Span {
+	return Span{Start: node.Pos(), End: node.End()}
+}
+
+// Codes identify the kind of problem independently of the message
+const (
+	UnexpectedCharacter = "unexpected-character"
+	UnterminatedString = "unterminated-string"
+	UnterminatedComment = "unterminated-comment"
+	InvalidEscape = "invalid-escape"
+	InvalidEncoding = "invalid-encoding"
+	InvalidNumber = "invalid-number"
+	SyntaxError = "syntax-error"
+	InvalidAssignment = "invalid-assignment-target"
+	TooManyArguments = "too-many-arguments"
+	Redeclaration = "redeclaration"
+	SelfInitializer = "self-initializer"
+	SelfInheritance = "self-inheritance"
+	InvalidReturn = "invalid-return"
+	InvalidThis = "invalid-this"
+	InvalidSuper = "invalid-super"
+	InvalidBreak = "invalid-break"
+	InvalidContinue = "invalid-continue"
+	InvalidYield = "invalid-yield"
+	RuntimeError = "runtime-error"
+)
+
+// Diagnostic is a problem found in the source
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code string `json:"code"`
+	Message string `json:"message"`
+	Span Span `json:"span"`
+	Notes []Annotation `json:"notes,omitempty"`
+}
+
+// Annotation is secondary information about a diagnostic, such as where a
+// redeclared variable was first declared. Span may be zero.
+type Annotation struct {
+	Message string `json:"message"`
+	Span Span `json:"span"`
+}
+
+func New(code string, span Span, message string) *Diagnostic {
+	return &Diagnostic{Severity: Error, Code: code, Message: message, Span: span}
+}
+
+// WithNote adds a note and returns d
+func (d *Diagnostic) WithNote(span Span, message string) *Diagnostic {
+	d.Notes = append(d.Notes, Annotation{Message: message, Span: span})
+	return d
+}
+
+func (d *Diagnostic) Error() string {
+	if !d.Span.Start.IsValid() {
+		return fmt.Sprintf("%v[%v]: %v", d.Severity, d.Code, d.Message)
+	}
+	return fmt.Sprintf("%v: %v[%v]: %v", d.Span.Start, d.Severity, d.Code, d.Message)
+}
+
+// List collects the diagnostics of a stage. A non-empty List is an error.
+type List []*Diagnostic
+
+func (l List) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%v (and %v more errors)", l[0].Error(), len(l)-1)
+}
+
+// HasErrors reports whether any diagnostic has Error severity
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the list as an error, or nil if it has no errors
+func (l List) Err() error {
+	if !l.HasErrors() {
+		return nil
+	}
+	return l
+}
+
+// Text renders every diagnostic on its own line to w
+func (l List) Text(w io.Writer) error {
+	var sb strings.Builder
+	for _, d := range l {
+		sb.WriteString(d.Error())
+		sb.WriteString("\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// JSON encodes the list as an array of diagnostics
+func (l List) JSON() ([]byte, error) {
+	if l == nil {
+		l = List{}
+	}
+	return json.Marshal(l)
+}
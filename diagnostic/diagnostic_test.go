@@ -0,0 +1,127 @@
+package diagnostic_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/parser"
+	"github.com/singurty/lox/resolver"
+	"github.com/singurty/lox/scanner"
+)
+
+func TestScannerDiagnostics(t *testing.T) {
+	sc := scanner.New("var a = 1;\nvar b = @;\nvar c = \"open")
+	sc.ScanTokens()
+	expected := "2:9: error[unexpected-character]: Unexpected character: @\n" +
+		"3:9: error[unterminated-string]: Unterminated string\n"
+	sb := &strings.Builder{}
+	sc.Diagnostics.Text(sb)
+	if sb.String() != expected {
+		t.Errorf("Expected diagnostics:\n%v\nGot:\n%v", expected, sb.String())
+	}
+}
+
+func TestParserDiagnostics(t *testing.T) {
+	sc := scanner.New("print 1 +;\nvar = 2;\nprint 3;")
+	p := parser.New(sc.ScanTokens())
+	statements := p.Parse()
+	if len(statements) != 1 {
+		t.Errorf("Expected the valid statement to be parsed, got %v statements", len(statements))
+	}
+	expected := "1:10: error[syntax-error]: Expected expression.\n" +
+		"2:5: error[syntax-error]: Expected variable name\n"
+	sb := &strings.Builder{}
+	p.Diagnostics.Text(sb)
+	if sb.String() != expected {
+		t.Errorf("Expected diagnostics:\n%v\nGot:\n%v", expected, sb.String())
+	}
+}
+
+func TestResolverDiagnostics(t *testing.T) {
+	sc := scanner.New(`{ var a; var a; }
+return 1;
+fun f() { print this; }
+while (true) { fun g() { break; } }
+while (true) { var h = fun () { continue; }; }`)
+	p := parser.New(sc.ScanTokens())
+	statements := p.Parse()
+	if p.HadError {
+		t.Fatal(p.Diagnostics)
+	}
+	r := resolver.NewResolver()
+	err := r.Resolve(statements)
+	if err == nil {
+		t.Fatal("Expected resolver errors")
+	}
+	codes := []string{diagnostic.Redeclaration, diagnostic.InvalidReturn, diagnostic.InvalidThis, diagnostic.InvalidBreak, diagnostic.InvalidContinue}
+	if len(r.Diagnostics) != len(codes) {
+		t.Fatalf("Expected %v diagnostics got %v", len(codes), r.Diagnostics)
+	}
+	for i, code := range codes {
+		if r.Diagnostics[i].Code != code {
+			t.Errorf("Expected diagnostic %v to be %v got %v instead", i, code, r.Diagnostics[i].Code)
+		}
+	}
+	json, err := r.Diagnostics[:1].JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"severity":"error","code":"redeclaration","message":"A variable with the same name already exists in this scope",` +
+		`"span":{"start":{"offset":13,"line":1,"column":14},"end":{"offset":14,"line":1,"column":15}},` +
+		`"notes":[{"message":"variable declared here","span":{"start":{"offset":6,"line":1,"column":7},"end":{"offset":7,"line":1,"column":8}}}]}]`
+	if string(json) != expected {
+		t.Errorf("Expected JSON:\n%v\nGot:\n%v", expected, string(json))
+	}
+}
+
+func TestRender(t *testing.T) {
+	source := "var a = 1;\n{\n\tvar b = 2;\n\tvar b = \"é\" + 3;\n}\n"
+	sc := scanner.New(source)
+	p := parser.New(sc.ScanTokens())
+	r := resolver.NewResolver()
+	r.Resolve(p.Parse())
+	sb := &strings.Builder{}
+	r.Diagnostics.Render(sb, "test.lox", source)
+	expected := `error[redeclaration]: A variable with the same name already exists in this scope
+ --> test.lox:4:6
+  |
+4 | 	var b = "é" + 3;
+  | 	    ^
+  |
+3 | 	var b = 2;
+  | 	    - variable declared here
+
+`
+	if sb.String() != expected {
+		t.Errorf("Expected rendering:\n%v\nGot:\n%v", expected, sb.String())
+	}
+
+	d := diagnostic.New(diagnostic.SyntaxError, diagnostic.Span{}, "Something went wrong")
+	d.WithNote(diagnostic.Span{}, "no position")
+	sb.Reset()
+	d.Render(sb, "test.lox", source)
+	expected = "error[syntax-error]: Something went wrong\n  = note: no position\n"
+	if sb.String() != expected {
+		t.Errorf("Expected rendering:\n%v\nGot:\n%v", expected, sb.String())
+	}
+}
+
+func TestRenderUnderline(t *testing.T) {
+	source := "print \"é\" + 1;\nprint 2;"
+	sc := scanner.New(source)
+	tokens := sc.ScanTokens()
+	// the string literal, multibyte characters get one caret each
+	d := diagnostic.New(diagnostic.RuntimeError, diagnostic.TokenSpan(tokens[1]), "Operands must be eithier numbers or strings")
+	sb := &strings.Builder{}
+	d.Render(sb, "test.lox", source)
+	expected := `error[runtime-error]: Operands must be eithier numbers or strings
+ --> test.lox:1:7
+  |
+1 | print "é" + 1;
+  |       ^^^
+`
+	if sb.String() != expected {
+		t.Errorf("Expected rendering:\n%v\nGot:\n%v", expected, sb.String())
+	}
+}
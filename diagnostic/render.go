@@ -0,0 +1,102 @@
+package diagnostic
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Render writes d to w in the style of rustc: the message, the file and
+// position, and the offending source line with the span underlined by
+// carets. Notes with a span quote their own line and are underlined with
+// dashes. source must be the text the spans refer to.
+//
+//	error[redeclaration]: A variable with the same name already exists in this scope
+//	 --> script.lox:2:5
+//	  |
+//	2 | var a;
+//	  |     ^
+//	  |
+//	1 | var a = 1;
+//	  |     - variable declared here
+func (d *Diagnostic) Render(w io.Writer, filename string, source string) error {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%v[%v]: %v\n", d.Severity, d.Code, d.Message))
+	width := len(strconv.Itoa(d.Span.Start.Line))
+	for _, note := range d.Notes {
+		if n := len(strconv.Itoa(note.Span.Start.Line)); n > width {
+			width = n
+		}
+	}
+	gutter := strings.Repeat(" ", width)
+	if d.Span.Start.IsValid() {
+		sb.WriteString(fmt.Sprintf("%v--> %v:%v\n", gutter, filename, d.Span.Start))
+		writeSnippet(&sb, source, d.Span, '^', "", width)
+	}
+	for _, note := range d.Notes {
+		if note.Span.Start.IsValid() {
+			writeSnippet(&sb, source, note.Span, '-', note.Message, width)
+		} else {
+			sb.WriteString(fmt.Sprintf("%v = note: %v\n", gutter, note.Message))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Render renders every diagnostic followed by a blank line
+func (l List) Render(w io.Writer, filename string, source string) error {
+	for _, d := range l {
+		err := d.Render(w, filename, source)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSnippet quotes the line span starts on and underlines the span up to
+// the end of that line with mark
+func writeSnippet(sb *strings.Builder, source string, span Span, mark byte, label string, width int) {
+	start := span.Start.Offset
+	if start > len(source) {
+		return
+	}
+	lineStart := strings.LastIndexByte(source[:start], '\n') + 1
+	lineEnd := len(source)
+	if i := strings.IndexByte(source[start:], '\n'); i >= 0 {
+		lineEnd = start + i
+	}
+	end := span.End.Offset
+	if end > lineEnd {
+		end = lineEnd
+	}
+	count := 1
+	if end > start {
+		count = utf8.RuneCountInString(source[start:end])
+	}
+	// keep tabs so the marks line up with the quoted source
+	var indent strings.Builder
+	for _, c := range source[lineStart:start] {
+		if c == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+	gutter := strings.Repeat(" ", width)
+	line := strings.TrimRight(source[lineStart:lineEnd], "\r")
+	sb.WriteString(fmt.Sprintf("%v |\n", gutter))
+	sb.WriteString(fmt.Sprintf("%*d | %v\n", width, span.Start.Line, line))
+	marks := indent.String() + strings.Repeat(string(mark), count)
+	if label != "" {
+		marks += " " + label
+	}
+	sb.WriteString(fmt.Sprintf("%v | %v\n", gutter, marks))
+}
@@ -2,9 +2,13 @@ package environment
 
 import (
 	"errors"
+	"sync"
 )
 
+// Environment maps variable names to values. Environments can be shared by
+// tasks running concurrently, so every access is synchronized.
 type Environment struct {
+	mu sync.RWMutex
 	environment map[string]interface{}
 	Enclosing *Environment
 }
@@ -17,7 +21,18 @@ func Local(Enclosing *Environment) *Environment {
 	return &Environment{environment: make(map[string]interface{}), Enclosing: Enclosing}
 }
 
+// uninitialized is the value of a variable declared without a value until
+// it is assigned. Reading it is an error, unlike reading null.
+type uninitialized struct{}
+
+// Declare defines variable without a value
+func (e *Environment) Declare(variable string) error {
+	return e.Define(variable, uninitialized{})
+}
+
 func (e *Environment) Define(variable string, value interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	_, ok := e.environment[variable]
 	if ok {
 		return errors.New("Redeclaration of \"" + variable + "\"")
@@ -27,8 +42,7 @@ func (e *Environment) Define(variable string, value interface{}) error {
 }
 
 func (e *Environment) Assign(variable string, value interface{}) error {
-	if _, ok := e.environment[variable]; ok {
-		e.environment[variable] = value
+	if e.assign(variable, value) {
 		return nil
 	} else {
 		if e.Enclosing != nil {
@@ -39,19 +53,28 @@ func (e *Environment) Assign(variable string, value interface{}) error {
 }
 
 func (e *Environment) AssignAt(distance int, variable string, value interface{}) error {
-	env := e.ancestor(distance).environment
-	if _, ok := env[variable]; ok {
-		env[variable] = value
+	if e.ancestor(distance).assign(variable, value) {
 		return nil
 	} else {
 		return errors.New("Undefined variable \"" + variable + "\"")
 	}
 }
 
+// assign sets a variable declared in e and reports whether there was one
+func (e *Environment) assign(variable string, value interface{}) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.environment[variable]; ok {
+		e.environment[variable] = value
+		return true
+	}
+	return false
+}
+
 func (e *Environment) Get(variable string) (interface{}, error) {
-	value, ok := e.environment[variable]
+	value, ok := e.get(variable)
 	if ok {
-		if value == nil {
+		if _, ok := value.(uninitialized); ok {
 			return nil, errors.New("Uninitialized variable \"" + variable + "\"")
 		}
 		return value, nil
@@ -64,8 +87,8 @@ func (e *Environment) Get(variable string) (interface{}, error) {
 }
 
 func (e *Environment) GetAt(distance int, variable string) (interface{}, error) {
-	if value, ok := e.ancestor(distance).environment[variable]; ok {
-		if value == nil {
+	if value, ok := e.ancestor(distance).get(variable); ok {
+		if _, ok := value.(uninitialized); ok {
 			return nil, errors.New("Uninitialized variable \"" + variable + "\"")
 		}
 		return value, nil
@@ -74,6 +97,14 @@ func (e *Environment) GetAt(distance int, variable string) (interface{}, error)
 	}
 }
 
+func (e *Environment) get(variable string) (interface{}, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	value, ok := e.environment[variable]
+	return value, ok
+}
+
+// Enclosing never changes, so walking the chain needs no locking
 func (e *Environment) ancestor(distance int) *Environment {
 	env := e
 	for i := 0; i < distance; i++ {
@@ -0,0 +1,67 @@
+package interpreter
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
+)
+
+// begin starts a run with ctx and returns a function that ends it, given
+// the error the run returns. Budgets are reset when the outermost run
+// starts, so callbacks from host code share the budget of the script that
+// triggered them. The outermost run is the main task of the script, it
+// ends once the tasks spawned during it finished.
+func (interp *Interpreter) begin(ctx context.Context) func(err *error) {
+	if interp.active == 0 {
+		atomic.StoreInt64(&interp.steps, 0)
+		atomic.StoreInt64(&interp.instances, 0)
+		ctx, interp.tasks.cancel = context.WithCancel(ctx)
+		interp.scheduler.startTask()
+	}
+	interp.active++
+	previous := interp.ctx
+	interp.ctx = ctx
+	return func(err *error) {
+		interp.active--
+		if interp.active == 0 {
+			*err = interp.waitTasks(*err)
+		}
+		interp.ctx = previous
+	}
+}
+
+// step counts an executed statement against Options.MaxSteps
+func (interp *Interpreter) step(statement ast.Stmt) error {
+	steps := atomic.AddInt64(&interp.steps, 1)
+	if interp.options.MaxSteps > 0 && steps > int64(interp.options.MaxSteps) {
+		return &RuntimeError{Line: statement.Pos().Line, Span: diagnostic.NodeSpan(statement), Message: "Step budget exhausted"}
+	}
+	return nil
+}
+
+// enterCall pushes a frame for a call to fn from line and must be paired
+// with exitCall when it succeeds
+func (interp *Interpreter) enterCall(fn callable, line int) error {
+	if interp.options.MaxCallDepth > 0 && len(interp.frames) >= interp.options.MaxCallDepth {
+		err := &RuntimeError{Line: line, Message: "Stack overflow"}
+		interp.attachTrace(err)
+		return err
+	}
+	interp.frames = append(interp.frames, StackFrame{Function: frameName(fn), Line: line})
+	return nil
+}
+
+func (interp *Interpreter) exitCall() {
+	interp.frames = interp.frames[:len(interp.frames)-1]
+}
+
+// countInstance counts a new instance against Options.MaxInstances
+func (interp *Interpreter) countInstance() error {
+	instances := atomic.AddInt64(&interp.instances, 1)
+	if interp.options.MaxInstances > 0 && instances > int64(interp.options.MaxInstances) {
+		return &RuntimeError{Message: "Instance limit exceeded"}
+	}
+	return nil
+}
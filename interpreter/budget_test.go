@@ -0,0 +1,73 @@
+package interpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBudgets(t *testing.T) {
+	tests := []struct {
+		options *Options
+		input string
+		message string
+	}{
+		{
+			&Options{},
+			`fun recurse(n) { return recurse(n + 1); } recurse(0);`,
+			"[Line 1] RuntimeError: Stack overflow",
+		},
+		{
+			&Options{MaxCallDepth: 10},
+			`fun depth(n) { if (n == 0) return 0; return depth(n - 1); } depth(10);`,
+			"Stack overflow",
+		},
+		{
+			&Options{MaxSteps: 100},
+			`while (true) {}`,
+			"[Line 1] RuntimeError: Step budget exhausted",
+		},
+		{
+			&Options{MaxSteps: 100},
+			`fun spin() { while (true) {} }
+			spin();`,
+			"[Line 1] RuntimeError: Step budget exhausted",
+		},
+		{
+			&Options{MaxInstances: 3},
+			`class A {} for (var i = 0; i < 4; i = i + 1) { A(); }`,
+			"Instance limit exceeded",
+		},
+	}
+	for _, test := range tests {
+		err := runSource(New(test.options), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestBudgetsWithinLimits(t *testing.T) {
+	interp := New(&Options{MaxCallDepth: 11, MaxSteps: 1000, MaxInstances: 3})
+	input := `
+	class A {}
+	fun depth(n) { if (n == 0) return 0; return depth(n - 1); }
+	depth(10);
+	A(); A(); A();
+	`
+	// budgets are per run so the same source succeeds repeatedly
+	for i := 0; i < 3; i++ {
+		err := runSource(interp, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a failed run leaves the interpreter usable
+	err := runSource(interp, `depth(20);`)
+	if err == nil || !strings.Contains(err.Error(), "Stack overflow") {
+		t.Fatalf("Expected stack overflow, got %v", err)
+	}
+	err = runSource(interp, `depth(10);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,116 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Function is a handle to a lox function, lambda, bound method, class or
+// native function that can be invoked from Go. It is tied to the interpreter
+// it came from and, like the interpreter, must not be called concurrently.
+type Function struct {
+	interp *Interpreter
+	fn callable
+}
+
+// Arity is the number of arguments the function expects or Variadic.
+func (f *Function) Arity() int {
+	return f.fn.arity()
+}
+
+func (f *Function) String() string {
+	return f.fn.String()
+}
+
+// Call invokes the function with args, converting each with Marshal.
+func (f *Function) Call(args ...interface{}) (value interface{}, err error) {
+	defer f.interp.begin(f.interp.ctx)(&err)
+	return f.call(args)
+}
+
+// CallContext is like Call but stops with a *CancelledError once ctx is
+// done.
+func (f *Function) CallContext(ctx context.Context, args ...interface{}) (value interface{}, err error) {
+	defer f.interp.begin(ctx)(&err)
+	return f.call(args)
+}
+
+func (f *Function) call(args []interface{}) (interface{}, error) {
+	arity := f.fn.arity()
+	if arity != Variadic && len(args) != arity {
+		return nil, errors.New("Expected " + strconv.Itoa(arity) + " arguments but got " + strconv.Itoa(len(args)))
+	}
+	arguments := make([]interface{}, len(args))
+	for i, arg := range args {
+		argument, err := Marshal(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %v: %v", i+1, err.Error())
+		}
+		arguments[i] = argument
+	}
+	err := f.interp.enterCall(f.fn, 0)
+	if err != nil {
+		return nil, err
+	}
+	value, err := f.fn.call(f.interp, arguments)
+	f.interp.attachTrace(err)
+	f.interp.exitCall()
+	return value, err
+}
+
+// Get returns the value of a global variable.
+func (interp *Interpreter) Get(name string) (interface{}, error) {
+	return interp.global.Get(name)
+}
+
+// Function looks up a global callable by name.
+func (interp *Interpreter) Function(name string) (*Function, error) {
+	value, err := interp.global.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return interp.Callable(value)
+}
+
+// Callable wraps a lox value, such as one returned from a script, in a
+// Function handle.
+func (interp *Interpreter) Callable(value interface{}) (*Function, error) {
+	fn, ok := value.(callable)
+	if !ok {
+		return nil, errors.New("Can only call functions, got " + typeName(value))
+	}
+	return &Function{interp: interp, fn: fn}, nil
+}
+
+// Call invokes the global function or class name with args.
+func (interp *Interpreter) Call(name string, args ...interface{}) (interface{}, error) {
+	fn, err := interp.Function(name)
+	if err != nil {
+		return nil, err
+	}
+	return fn.Call(args...)
+}
+
+// Method returns the method name of instance bound to it.
+func (interp *Interpreter) Method(instance *Instance, name string) (*Function, error) {
+	method := instance.klass.findMethod(name)
+	if method == nil {
+		return nil, errors.New("Undefined method \"" + name + "\" on " + instance.String())
+	}
+	bound, err := method.Bind(instance)
+	if err != nil {
+		return nil, err
+	}
+	return &Function{interp: interp, fn: bound}, nil
+}
+
+// CallMethod invokes the method name on instance with args.
+func (interp *Interpreter) CallMethod(instance *Instance, name string, args ...interface{}) (interface{}, error) {
+	method, err := interp.Method(instance, name)
+	if err != nil {
+		return nil, err
+	}
+	return method.Call(args...)
+}
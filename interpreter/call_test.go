@@ -0,0 +1,109 @@
+package interpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallFromGo(t *testing.T) {
+	interp := runTest(`
+	fun add(a, b) {
+		return a + b;
+	}
+	var square = fun (n) {
+		return n * n;
+	};
+	class Greeter {
+		init(name) {
+			this.name = name;
+		}
+		greet(greeting) {
+			return greeting + ", " + this.name;
+		}
+	}
+	var notFunction = 1;
+	`, nil, t)
+	value, err := interp.Call("add", 1, 2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 3.5 {
+		t.Errorf("Expected add(1, 2.5) to be 3.5 got %v instead", value)
+	}
+	value, err = interp.Call("square", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != int64(16) {
+		t.Errorf("Expected square(4) to be 16 got %v instead", value)
+	}
+	value, err = interp.Call("Greeter", "lox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, ok := value.(*Instance)
+	if !ok {
+		t.Fatalf("Expected an instance got %v instead", value)
+	}
+	value, err = interp.CallMethod(instance, "greet", "Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "Hello, lox" {
+		t.Errorf("Expected \"Hello, lox\" got %v instead", value)
+	}
+	greet, err := interp.Method(instance, "greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if greet.Arity() != 1 {
+		t.Errorf("Expected greet to take 1 argument got %v instead", greet.Arity())
+	}
+
+	errorTests := []struct {
+		call func() (interface{}, error)
+		message string
+	}{
+		{func() (interface{}, error) { return interp.Call("add", 1) }, "Expected 2 arguments but got 1"},
+		{func() (interface{}, error) { return interp.Call("missing") }, "Undefined variable \"missing\""},
+		{func() (interface{}, error) { return interp.Call("notFunction") }, "Can only call functions"},
+		{func() (interface{}, error) { return interp.Call("add", "a", 1) }, "Operands must be"},
+		{func() (interface{}, error) { return interp.CallMethod(instance, "wave") }, "Undefined method \"wave\""},
+	}
+	for _, test := range errorTests {
+		_, err := test.call()
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q, got %v", test.message, err)
+		}
+	}
+}
+
+func TestCallableFromScript(t *testing.T) {
+	sb := &strings.Builder{}
+	interp := runTest(`
+	fun makeCounter() {
+		var i = 0;
+		return fun () {
+			i = i + 1;
+			print i;
+			return i;
+		};
+	}
+	`, &Options{PrintOutput: sb}, t)
+	counter, err := interp.Call("makeCounter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, err := interp.Callable(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := fn.Call(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sb.String() != "1\n2\n3\n" {
+		t.Errorf("Expected counter to print 1 to 3 got %q instead", sb.String())
+	}
+}
@@ -0,0 +1,272 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/token"
+)
+
+var (
+	errSendOnClosed = errors.New("Send on closed channel")
+	errForeignChannel = errors.New("Channel belongs to another interpreter")
+)
+
+// Channel passes values between the tasks of the interpreter that created
+// it with the chan native. Its fields are guarded by s.mu, which lets the
+// scheduler tell when every task is stuck.
+type Channel struct {
+	s *scheduler
+	capacity int
+	buffer []interface{}
+	closed bool
+	// operations of waiting tasks, oldest first
+	senders []*channelCase
+	receivers []*channelCase
+}
+
+// channelCase is a send or receive a task offers. select offers several at
+// once and the first one another task takes ends the wait.
+type channelCase struct {
+	c *Channel
+	send bool
+	value interface{} // sent value
+	index int // position of the case in select
+	w *waiter // set while queued on c
+}
+
+// newChannel implements the chan native: chan() makes an unbuffered channel,
+// where a send waits for a receive, and chan(n) one that holds up to n values
+// nobody received yet
+func newChannel(interp *Interpreter, args []interface{}) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("Expected 0 or 1 arguments but got %v", len(args))
+	}
+	var capacity int64
+	if len(args) == 1 {
+		var ok bool
+		capacity, ok = args[0].(int64)
+		if !ok || capacity < 0 {
+			return nil, fmt.Errorf("Channel capacity must be a non-negative integer, got %v", stringify(args[0]))
+		}
+	}
+	return &Channel{s: &interp.scheduler, capacity: int(capacity)}, nil
+}
+
+func (c *Channel) String() string {
+	return "<channel>"
+}
+
+// send waits until a task receives value or the channel has room for it
+func (c *Channel) send(interp *Interpreter, value interface{}) error {
+	_, _, _, err := interp.communicate([]*channelCase{{c: c, send: true, value: value}})
+	return err
+}
+
+// receive waits for a value. ok is false once the channel is closed and
+// every value sent before was received.
+func (c *Channel) receive(interp *Interpreter) (value interface{}, ok bool, err error) {
+	_, value, ok, err = interp.communicate([]*channelCase{{c: c}})
+	return value, ok, err
+}
+
+// close makes receives return null once the values already sent are
+// received and sends fail
+func (c *Channel) close(interp *Interpreter) error {
+	if c.s != &interp.scheduler {
+		return errForeignChannel
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	if c.closed {
+		return errors.New("Channel already closed")
+	}
+	c.closed = true
+	for len(c.receivers) > 0 {
+		receiver := c.receivers[0]
+		receiver.w.chosen = receiver.index
+		c.s.wake(receiver.w)
+	}
+	for len(c.senders) > 0 {
+		sender := c.senders[0]
+		sender.w.err = errSendOnClosed
+		c.s.wake(sender.w)
+	}
+	return nil
+}
+
+// communicate carries out one of cases, waiting until one can go ahead. Like
+// Go's select it picks at random when several can. It returns the position
+// of that case and, for a receive, the value and whether the channel was
+// still open.
+func (interp *Interpreter) communicate(cases []*channelCase) (int, interface{}, bool, error) {
+	s := &interp.scheduler
+	for _, c := range cases {
+		if c.c.s != s {
+			return 0, nil, false, errForeignChannel
+		}
+	}
+	s.mu.Lock()
+	for _, i := range rand.Perm(len(cases)) {
+		if value, ok, done, err := cases[i].try(); done {
+			s.mu.Unlock()
+			return i, value, ok, err
+		}
+	}
+	w := newWaiter()
+	for i, c := range cases {
+		c.index, c.w = i, w
+		if c.send {
+			c.c.senders = append(c.c.senders, c)
+		} else {
+			c.c.receivers = append(c.c.receivers, c)
+		}
+	}
+	w.cases = cases
+	err := s.park(interp, w)
+	if err == nil {
+		err = w.err
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return w.chosen, w.value, w.ok, nil
+}
+
+// try carries out the case if that doesn't need waiting and reports whether
+// it did. The mutex of the scheduler of the channel must be held.
+func (c *channelCase) try() (value interface{}, ok bool, done bool, err error) {
+	ch := c.c
+	if c.send {
+		if ch.closed {
+			return nil, false, true, errSendOnClosed
+		}
+		if len(ch.receivers) > 0 {
+			receiver := ch.receivers[0]
+			receiver.w.chosen, receiver.w.value, receiver.w.ok = receiver.index, c.value, true
+			ch.s.wake(receiver.w)
+			return nil, false, true, nil
+		}
+		if len(ch.buffer) < ch.capacity {
+			ch.buffer = append(ch.buffer, c.value)
+			return nil, false, true, nil
+		}
+		return nil, false, false, nil
+	}
+	if len(ch.buffer) > 0 {
+		value = ch.buffer[0]
+		ch.buffer = ch.buffer[1:]
+		// the oldest waiting sender takes the free slot
+		if len(ch.senders) > 0 {
+			sender := ch.senders[0]
+			ch.buffer = append(ch.buffer, sender.value)
+			sender.w.chosen = sender.index
+			ch.s.wake(sender.w)
+		}
+		return value, true, true, nil
+	}
+	if len(ch.senders) > 0 {
+		sender := ch.senders[0]
+		sender.w.chosen = sender.index
+		ch.s.wake(sender.w)
+		return sender.value, true, true, nil
+	}
+	if ch.closed {
+		return nil, false, true, nil
+	}
+	return nil, false, false, nil
+}
+
+// withdraw removes the case from the queue of its channel. The mutex of the
+// scheduler of the channel must be held.
+func (c *channelCase) withdraw() {
+	queue := &c.c.receivers
+	if c.send {
+		queue = &c.c.senders
+	}
+	for i, queued := range *queue {
+		if queued == c {
+			*queue = append((*queue)[:i], (*queue)[i+1:]...)
+			return
+		}
+	}
+}
+
+// get returns the native method name bound to the channel
+func (c *Channel) get(name token.Token) (interface{}, error) {
+	method := func(arity int, fn taskFunc) (interface{}, error) {
+		return &nativeFunction{name: name.Lexeme, arityNum: arity, taskCallable: fn}, nil
+	}
+	switch name.Lexeme {
+	case "send":
+		return method(1, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			return nil, c.send(interp, args[0])
+		})
+	case "receive":
+		return method(0, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			value, _, err := c.receive(interp)
+			return value, err
+		})
+	case "close":
+		return method(0, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			return nil, c.close(interp)
+		})
+	}
+	return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: "Undefined property \"" + name.Lexeme + "\"."}
+}
+
+// selectChannels implements the select native. Its argument lists the cases:
+// a channel to receive from or a [channel, value] list to send value on. It
+// waits until one of them can go ahead, picking at random when several can,
+// and returns a list of the position of that case and the value received,
+// null for a send or a closed channel.
+func selectChannels(interp *Interpreter, args []interface{}) (interface{}, error) {
+	list, ok := args[0].(*List)
+	if !ok {
+		return nil, fmt.Errorf("select expects a list of cases, got %v", typeName(args[0]))
+	}
+	elements := list.snapshot()
+	if len(elements) == 0 {
+		return nil, errors.New("select needs at least one case")
+	}
+	cases := make([]*channelCase, len(elements))
+	for i, element := range elements {
+		switch element := element.(type) {
+		case *Channel:
+			cases[i] = &channelCase{c: element}
+			continue
+		case *List:
+			pair := element.snapshot()
+			if len(pair) == 2 {
+				if channel, ok := pair[0].(*Channel); ok {
+					cases[i] = &channelCase{c: channel, send: true, value: pair[1]}
+					continue
+				}
+			}
+		}
+		return nil, fmt.Errorf("Case %v must be a channel or a [channel, value] list, got %v", i, stringify(element))
+	}
+	chosen, value, _, err := interp.communicate(cases)
+	if err != nil {
+		return nil, err
+	}
+	return newList([]interface{}{int64(chosen), value}), nil
+}
+
+// channelIterator receives from a channel until it is closed
+type channelIterator struct {
+	interp *Interpreter
+	c *Channel
+	line int
+	span diagnostic.Span
+}
+
+func (it *channelIterator) next() (interface{}, bool, error) {
+	value, ok, err := it.c.receive(it.interp)
+	if err == errDeadlock || err == errForeignChannel {
+		return nil, false, &RuntimeError{Line: it.line, Span: it.span, Message: err.Error()}
+	}
+	return value, ok, err
+}
@@ -1,13 +1,25 @@
 package interpreter
 
 import (
+	"sync"
+
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
 	"github.com/singurty/lox/token"
 )
 
 type class struct {
 	name string
 	superClass *class
-	methods map[string]*userFunction
+	methods map[string]*userFunction // methods and getters
+	setters map[string]*userFunction
+	// metaclass holds the class methods, it is nil for classes created from
+	// Go types
+	metaclass *class
+	mu sync.RWMutex // tasks can share classes
+	// fields holds the class-level fields, it is nil for classes created
+	// from Go types and for metaclasses
+	fields map[string]interface{}
 }
 
 func (c *class) String() string {
@@ -22,7 +34,11 @@ func (c *class) arity() int {
 	return 0
 }
 
-func (c *class) call(arguments []interface{}) (interface{}, error) {
+func (c *class) call(interp *Interpreter, arguments []interface{}) (interface{}, error) {
+	err := interp.countInstance()
+	if err != nil {
+		return nil, err
+	}
 	instance := newInstance(c)
 	initializer := c.findMethod("init")
 	if initializer != nil {
@@ -30,7 +46,7 @@ func (c *class) call(arguments []interface{}) (interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		_, err = initializer.call(arguments)
+		_, err = initializer.call(interp, arguments)
 		if err != nil {
 			return nil, err
 		}
@@ -38,6 +54,49 @@ func (c *class) call(arguments []interface{}) (interface{}, error) {
 	return instance, nil
 }
 
+// get returns a class-level field, a class method bound to the class, or
+// runs a class getter
+func (c *class) get(interp *Interpreter, name token.Token) (interface{}, error) {
+	if value, ok := c.field(name.Lexeme); ok {
+		return value, nil
+	}
+	if c.metaclass != nil {
+		if method := c.metaclass.findMethod(name.Lexeme); method != nil {
+			return interp.bindMethod(method, c, name)
+		}
+	}
+	return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: "Undefined property \"" + name.Lexeme + "\"."}
+}
+
+// field returns a class-level field. Subclasses see the fields of their
+// superclasses until they set their own.
+func (c *class) field(name string) (interface{}, bool) {
+	for klass := c; klass != nil; klass = klass.superClass {
+		klass.mu.RLock()
+		value, ok := klass.fields[name]
+		klass.mu.RUnlock()
+		if ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func (c *class) setField(name string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fields[name] = value
+}
+
+func (c *class) findSetter(name string) *userFunction {
+	if value, ok := c.setters[name]; ok {
+		return value
+	} else if c.superClass != nil {
+		return c.superClass.findSetter(name)
+	}
+	return nil
+}
+
 func (c *class) findMethod(name string) *userFunction {
 	if value, ok := c.methods[name]; ok {
 		return value
@@ -49,6 +108,7 @@ func (c *class) findMethod(name string) *userFunction {
 
 type Instance struct {
 	klass *class
+	mu sync.RWMutex // tasks can share instances
 	fields map[string]interface{}
 }
 
@@ -56,25 +116,91 @@ func newInstance(klass *class) *Instance {
 	return &Instance{klass: klass, fields: make(map[string]interface{})}
 }
 
+// ClassName returns the name of the instance's class.
+func (i *Instance) ClassName() string {
+	return i.klass.name
+}
+
+// Field returns the value of a field and whether it is set.
+func (i *Instance) Field(name string) (interface{}, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	value, ok := i.fields[name]
+	return value, ok
+}
+
+// fieldMap returns a copy of the fields
+func (i *Instance) fieldMap() map[string]interface{} {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	fields := make(map[string]interface{}, len(i.fields))
+	for name, value := range i.fields {
+		fields[name] = value
+	}
+	return fields
+}
+
 func (i *Instance) String() string {
 	return "<instance " + i.klass.name + ">"
 }
 
-func (i *Instance) get(name token.Token) (interface{}, error) {
-	if value, ok := i.fields[name.Lexeme]; ok {
+func (i *Instance) get(interp *Interpreter, name token.Token) (interface{}, error) {
+	if value, ok := i.Field(name.Lexeme); ok {
 		return value, nil
 	}
 	method := i.klass.findMethod(name.Lexeme)
 	if method != nil {
-		method, err := method.Bind(i)
-		if err != nil {
-			return nil, err
-		}
-		return method, nil
+		return interp.bindMethod(method, i, name)
 	}
-	return nil, &runtimeError{line: name.Line, message: "Undefined property \"" + name.Lexeme + "\"."}
+	return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: "Undefined property \"" + name.Lexeme + "\"."}
 }
 
 func (i *Instance) set(name string, value interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	i.fields[name] = value
 }
+
+// bindMethod binds method to this, running it right away when it is a
+// getter
+func (interp *Interpreter) bindMethod(method *userFunction, this interface{}, name token.Token) (interface{}, error) {
+	bound, err := method.Bind(this)
+	if err != nil {
+		return nil, err
+	}
+	if method.declaration.Getter {
+		return interp.callAccessor(bound, name, nil)
+	}
+	return bound, nil
+}
+
+// callAccessor runs a getter or setter like a call made where the property
+// is accessed
+func (interp *Interpreter) callAccessor(accessor *userFunction, name token.Token, arguments []interface{}) (interface{}, error) {
+	err := interp.enterCall(accessor, name.Line)
+	if err != nil {
+		err.(*RuntimeError).Span = diagnostic.TokenSpan(name)
+		return nil, err
+	}
+	value, err := accessor.call(interp, arguments)
+	interp.attachTrace(err)
+	interp.exitCall()
+	return value, err
+}
+
+// newClass creates a class whose methods close over the current environment
+func (interp *Interpreter) newClass(name string, superClass *class, declarations []*ast.Function) *class {
+	klass := &class{name: name, superClass: superClass, methods: make(map[string]*userFunction), setters: make(map[string]*userFunction)}
+	for _, declaration := range declarations {
+		function := &userFunction{declaration: declaration, closure: interp.env, module: interp.module}
+		if declaration.Setter {
+			klass.setters[declaration.Name.Lexeme] = function
+			continue
+		}
+		if declaration.Name.Lexeme == "init" && !declaration.Getter {
+			function.isInitializer = true
+		}
+		klass.methods[declaration.Name.Lexeme] = function
+	}
+	return klass
+}
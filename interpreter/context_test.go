@@ -0,0 +1,137 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/singurty/lox/parser"
+	"github.com/singurty/lox/resolver"
+	"github.com/singurty/lox/scanner"
+)
+
+func runSourceContext(ctx context.Context, interp *Interpreter, source string, t *testing.T) error {
+	scan := scanner.New(source)
+	parse := parser.New(scan.ScanTokens())
+	statements := parse.Parse()
+	if scan.HadError || parse.HadError {
+		t.Fatal("syntax error")
+	}
+	resolver := resolver.NewResolver()
+	err := resolver.Resolve(statements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return interp.InterpretContext(ctx, statements, resolver)
+}
+
+func TestInterpretContextTimeout(t *testing.T) {
+	inputs := []string{
+		`while (true) {}`,
+		`for (var i = 0; true; i = i + 1) {}`,
+		// 2^100 calls without a loop, nesting only 100 deep
+		`fun recurse(n) { if (n > 0) { recurse(n - 1); recurse(n - 1); } } recurse(100);`,
+	}
+	for _, input := range inputs {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := runSourceContext(ctx, New(nil), input, t)
+		cancel()
+		if !errors.Is(err, ErrCancelled) {
+			t.Errorf("Expected %q to be cancelled, got %v", input, err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected %q to unwrap to the deadline, got %v", input, err)
+		}
+	}
+}
+
+func TestInterpretContextCancelled(t *testing.T) {
+	interp := New(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := runSourceContext(ctx, interp, `var a = 1; while (a < 10) { a = a + 1; }`, t)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancellation, got %v", err)
+	}
+	// the interpreter is still usable afterwards
+	err = runSourceContext(context.Background(), interp, `while (a < 10) { a = a + 1; }`, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := interp.Get("a")
+	if a != int64(10) {
+		t.Errorf("Expected variable 'a' to be 10 got %v instead", a)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	interp := runTest(`fun spin() { while (true) {} }`, nil, t)
+	spin, err := interp.Function("spin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = spin.CallContext(ctx)
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+}
+
+func TestInterpretContextTasks(t *testing.T) {
+	// a task that keeps running could still unblock the others, so only
+	// the context stops them
+	inputs := []string{
+		`var c = chan(); fun spin() { while (true) {} } spawn spin(); c.receive();`,
+		`var c = chan(); fun wait() { c.receive(); } spawn wait(); while (true) {}`,
+		`fun spin() { while (true) {} } spawn spin().wait();`,
+		`var c = chan(); fun spin() { while (true) {} } spawn spin(); select([c, [chan(), 1]]);`,
+	}
+	for _, input := range inputs {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := runSourceContext(ctx, New(nil), input, t)
+		cancel()
+		if !errors.Is(err, ErrCancelled) {
+			t.Errorf("Expected %q to be cancelled, got %v", input, err)
+		}
+	}
+}
+
+func TestIndependentDeadlocks(t *testing.T) {
+	// a busy interpreter must not hide the deadlock of another one
+	busy, stop := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- runSourceContext(busy, New(nil), `while (true) {}`, t)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := runSourceContext(ctx, New(nil), `var c = chan(); c.receive();`, t)
+	stop()
+	if err == nil || errors.Is(err, ErrCancelled) || !strings.Contains(err.Error(), "Deadlock") {
+		t.Errorf("Expected a deadlock, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrCancelled) {
+		t.Errorf("Expected the busy interpreter to be cancelled, got %v", err)
+	}
+
+	// channels and tasks stay with the interpreter that created them
+	owner := runTest(`var c = chan(1); fun f() {} var task = spawn f();`, nil, t)
+	other := runTest(`fun receive(c) { return c.receive(); } fun wait(task) { return task.wait(); }`, nil, t)
+	for _, test := range []struct {
+		value string
+		function string
+		message string
+	}{
+		{"c", "receive", "Channel belongs to another interpreter"},
+		{"task", "wait", "Task belongs to another interpreter"},
+	} {
+		value, _ := owner.Get(test.value)
+		_, err := other.Call(test.function, value)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q, got %v", test.message, err)
+		}
+	}
+}
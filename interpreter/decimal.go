@@ -0,0 +1,230 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/token"
+)
+
+// digits after the decimal point kept by a division that doesn't terminate
+const decimalDivisionScale = 28
+
+// Decimal is an exact base-10 number, unscaled * 10^-scale. Decimals keep
+// the number of digits after the decimal point they were written with, so
+// decimal("1.10") prints as 1.10. Decimals are immutable.
+type Decimal struct {
+	unscaled *big.Int
+	scale int32 // never negative
+}
+
+// ParseDecimal parses a decimal number such as "-12.50".
+func ParseDecimal(s string) (*Decimal, error) {
+	digits := s
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	whole, fraction, hasPoint := strings.Cut(digits, ".")
+	if whole == "" || (hasPoint && fraction == "") || !isDigits(whole) || !isDigits(fraction) {
+		return nil, fmt.Errorf("Cannot convert %q to decimal", s)
+	}
+	unscaled, _ := new(big.Int).SetString(whole+fraction, 10)
+	if strings.HasPrefix(s, "-") {
+		unscaled.Neg(unscaled)
+	}
+	return &Decimal{unscaled: unscaled, scale: int32(len(fraction))}, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func decimalFromInt(n *big.Int) *Decimal {
+	return &Decimal{unscaled: n, scale: 0}
+}
+
+// decimalFromFloat converts a float using the shortest representation that
+// reads back as the same float, so 0.1 becomes 0.1 rather than its exact
+// binary value
+func decimalFromFloat(f float64) (*Decimal, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("Cannot convert %v to decimal", f)
+	}
+	return ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// toDecimal converts an integer or a decimal to a decimal
+func toDecimal(value interface{}) *Decimal {
+	if d, ok := value.(*Decimal); ok {
+		return d
+	}
+	return decimalFromInt(toBig(value))
+}
+
+func (d *Decimal) String() string {
+	digits := new(big.Int).Abs(d.unscaled).String()
+	sign := ""
+	if d.unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= int(d.scale) {
+		digits = strings.Repeat("0", int(d.scale)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(d.scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Rat returns the value of d as a fraction.
+func (d *Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(d.unscaled, pow10(d.scale))
+}
+
+// Float64 returns the float nearest to d.
+func (d *Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+// integer returns the integer part of d, truncated towards zero
+func (d *Decimal) integer() *big.Int {
+	return new(big.Int).Quo(d.unscaled, pow10(d.scale))
+}
+
+func (d *Decimal) isInteger() bool {
+	return new(big.Int).Rem(d.unscaled, pow10(d.scale)).Sign() == 0
+}
+
+// rescale returns the unscaled value of d at a scale not less than its own
+func (d *Decimal) rescale(scale int32) *big.Int {
+	return new(big.Int).Mul(d.unscaled, pow10(scale-d.scale))
+}
+
+// trim drops trailing zeros after the decimal point down to scale digits
+func (d *Decimal) trim(scale int32) *Decimal {
+	unscaled := new(big.Int).Set(d.unscaled)
+	s := d.scale
+	ten := big.NewInt(10)
+	remainder := new(big.Int)
+	for s > scale {
+		quotient, _ := new(big.Int).QuoRem(unscaled, ten, remainder)
+		if remainder.Sign() != 0 {
+			break
+		}
+		unscaled = quotient
+		s--
+	}
+	return &Decimal{unscaled: unscaled, scale: s}
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// decimalArithmetic applies an arithmetic operator to two decimals. Sums,
+// differences and products are exact, a quotient that doesn't terminate is
+// rounded half to even to decimalDivisionScale digits.
+func decimalArithmetic(operator token.Token, l, r *Decimal) (interface{}, error) {
+	divideByZero := &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Divide by zero"}
+	scale := l.scale
+	if r.scale > scale {
+		scale = r.scale
+	}
+	switch operator.Type {
+	case token.PLUS:
+		return &Decimal{unscaled: new(big.Int).Add(l.rescale(scale), r.rescale(scale)), scale: scale}, nil
+	case token.MINUS:
+		return &Decimal{unscaled: new(big.Int).Sub(l.rescale(scale), r.rescale(scale)), scale: scale}, nil
+	case token.STAR:
+		return &Decimal{unscaled: new(big.Int).Mul(l.unscaled, r.unscaled), scale: l.scale + r.scale}, nil
+	case token.SLASH:
+		if r.unscaled.Sign() == 0 {
+			return nil, divideByZero
+		}
+		return divideDecimals(l, r, scale), nil
+	case token.PERCENT, token.TILDE_SLASH:
+		if r.unscaled.Sign() == 0 {
+			return nil, divideByZero
+		}
+		quotient, remainder := new(big.Int).QuoRem(l.rescale(scale), r.rescale(scale), new(big.Int))
+		if operator.Type == token.PERCENT {
+			return &Decimal{unscaled: remainder, scale: scale}, nil
+		}
+		return &Decimal{unscaled: quotient, scale: 0}, nil
+	case token.STAR_STAR:
+		if !r.isInteger() {
+			return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Decimal exponent must be an integer"}
+		}
+		exponent := r.integer()
+		n := new(big.Int).Abs(exponent)
+		// 0, 1 and -1 can be raised to any power, other powers are limited
+		// like integer ones, counting digits after the point too
+		trivial := l.scale == 0 && l.unscaled.CmpAbs(big.NewInt(1)) <= 0
+		if !trivial && (!n.IsInt64() || n.Int64() > maxIntegerBits || int64(l.unscaled.BitLen()+4*int(l.scale))*n.Int64() > maxIntegerBits) {
+			return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Decimal too large"}
+		}
+		scale = 0
+		if !trivial {
+			scale = l.scale * int32(n.Int64())
+		}
+		power := &Decimal{unscaled: new(big.Int).Exp(l.unscaled, n, nil), scale: scale}
+		if exponent.Sign() >= 0 {
+			return power, nil
+		}
+		if power.unscaled.Sign() == 0 {
+			return nil, divideByZero
+		}
+		return divideDecimals(decimalFromInt(big.NewInt(1)), power, 0), nil
+	}
+	return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Unknown operator"}
+}
+
+// divideDecimals divides l by r, keeping at least scale digits after the
+// decimal point when the quotient is exact
+func divideDecimals(l, r *Decimal, scale int32) *Decimal {
+	precision := int32(decimalDivisionScale)
+	if scale > precision {
+		precision = scale
+	}
+	// l / r = (l.unscaled * 10^(precision + r.scale - l.scale) / r.unscaled) * 10^-precision
+	numerator := new(big.Int).Mul(l.unscaled, pow10(precision+r.scale-l.scale))
+	quotient, remainder := new(big.Int).QuoRem(numerator, r.unscaled, new(big.Int))
+	// round half to even
+	twice := new(big.Int).Abs(remainder)
+	twice.Lsh(twice, 1)
+	if c := twice.CmpAbs(r.unscaled); c > 0 || (c == 0 && quotient.Bit(0) == 1) {
+		if numerator.Sign()*r.unscaled.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return (&Decimal{unscaled: quotient, scale: precision}).trim(scale)
+}
+
+// toDecimalNative implements the decimal native. Floats are converted from
+// their shortest representation.
+func toDecimalNative(args []interface{}) (interface{}, error) {
+	switch value := args[0].(type) {
+	case *Decimal:
+		return value, nil
+	case int64, *big.Int:
+		return toDecimal(value), nil
+	case float64:
+		return decimalFromFloat(value)
+	case string:
+		return ParseDecimal(value)
+	}
+	return nil, fmt.Errorf("Cannot convert %v to decimal", typeName(args[0]))
+}
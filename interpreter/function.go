@@ -8,12 +8,18 @@ import (
 
 type callable interface {
 	arity() int
-	call([]interface{}) (interface{}, error)
+	call(*Interpreter, []interface{}) (interface{}, error)
 	String() string
 }
 
+// taskFunc is a native that needs the task calling it, to block until its
+// context is done or to run lox code
+type taskFunc func(interp *Interpreter, args []interface{}) (interface{}, error)
+
 type nativeFunction struct {
-	nativeCallable func([]interface{}) (interface{}, error)
+	name string
+	nativeCallable NativeFunc
+	taskCallable taskFunc // used instead of nativeCallable when set
 	arityNum int
 }
 
@@ -21,17 +27,24 @@ func (n *nativeFunction) arity() int {
 	return n.arityNum
 }
 
-func (n *nativeFunction) call(arguments []interface{}) (interface{}, error) {
+func (n *nativeFunction) call(interp *Interpreter, arguments []interface{}) (interface{}, error) {
+	if n.taskCallable != nil {
+		return n.taskCallable(interp, arguments)
+	}
 	return n.nativeCallable(arguments)
 }
 
 func (n *nativeFunction) String() string {
-	return "<native fun>"
+	if n.name == "" {
+		return "<native fun>"
+	}
+	return "<native fun " + n.name + ">"
 }
 
 type userFunction struct {
 	declaration *ast.Function
 	closure *environment.Environment
+	module *Module // module the function was declared in
 	isInitializer bool
 }
 
@@ -43,26 +56,35 @@ func (u *userFunction) String() string {
 	return "<fun " + u.declaration.Name.Lexeme + ">"
 }
 
-func (u *userFunction) call(arguments []interface{}) (interface{}, error) {
+func (u *userFunction) call(interp *Interpreter, arguments []interface{}) (interface{}, error) {
 	if u.isInitializer {
-		funCall(u.closure, u.declaration.Parameters, u.arity(), u.declaration.Body, arguments)
+		_, err := interp.funCall(u.module, u.closure, u.declaration.Parameters, u.arity(), u.declaration.Body, arguments)
+		if err != nil {
+			return nil, err
+		}
 		return u.closure.GetAt(0, "this")
 	}
-	return funCall(u.closure, u.declaration.Parameters, u.arity(), u.declaration.Body, arguments)
+	if u.declaration.Generator {
+		return interp.newGenerator(u.declaration.Name.Lexeme, u.module, u.closure, u.declaration.Parameters, u.arity(), u.declaration.Body, arguments), nil
+	}
+	return interp.funCall(u.module, u.closure, u.declaration.Parameters, u.arity(), u.declaration.Body, arguments)
 }
 
-func (u *userFunction) Bind(instance *Instance) (*userFunction, error) {
+// Bind returns the method with "this" set to an instance, or to a class for
+// class methods
+func (u *userFunction) Bind(this interface{}) (*userFunction, error) {
 	env := environment.Local(u.closure)
-	err := env.Define("this", instance)
+	err := env.Define("this", this)
 	if err != nil {
 		return nil, err
 	}
-	return &userFunction{declaration: u.declaration, closure: env, isInitializer: u.isInitializer}, nil
+	return &userFunction{declaration: u.declaration, closure: env, module: u.module, isInitializer: u.isInitializer}, nil
 }
 
 type lambda struct {
 	declaration *ast.Lambda
 	closure *environment.Environment
+	module *Module
 }
 
 func (l *lambda) arity() int {
@@ -73,22 +95,27 @@ func (l *lambda) String() string {
 	return "<lambda>"
 }
 
-func (l *lambda) call(arguments []interface{}) (interface{}, error) {
-	return funCall(l.closure, l.declaration.Parameters, l.arity(), l.declaration.Body, arguments)
+func (l *lambda) call(interp *Interpreter, arguments []interface{}) (interface{}, error) {
+	if l.declaration.Generator {
+		return interp.newGenerator("lambda", l.module, l.closure, l.declaration.Parameters, l.arity(), l.declaration.Body, arguments), nil
+	}
+	return interp.funCall(l.module, l.closure, l.declaration.Parameters, l.arity(), l.declaration.Body, arguments)
 }
 
-func funCall(closure *environment.Environment, parameters []token.Token, arity int, body []ast.Stmt, arguments []interface{}) (interface{}, error) {
+// funCall runs body with the globals of the module it was declared in
+func (interp *Interpreter) funCall(module *Module, closure *environment.Environment, parameters []token.Token, arity int, body []ast.Stmt, arguments []interface{}) (interface{}, error) {
+	defer interp.enterModule(module)()
 	envFun := environment.Local(closure)
 	for i := 0; i < arity; i++ {
 		envFun.Define(parameters[i].Lexeme, arguments[i])
 	}
-	err := executeBlock(body, envFun)
+	err := interp.executeBlock(body, envFun)
 	if err != nil {
 		returnValue, ok := err.(*returnError)
 		if ok {
 			return returnValue.value, nil
 		} else {
-			return nil, err
+			return nil, interp.locate(err)
 		}
 	}
 	return nil, nil
@@ -0,0 +1,238 @@
+package interpreter
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/environment"
+	"github.com/singurty/lox/token"
+)
+
+// Generator is returned by calling a function that contains yield. Its body
+// runs on its own goroutine with its own task state, but only while the
+// task that resumed it waits, so the body runs as if it were called by that
+// task. A generator still suspended when the outermost run ends is closed,
+// so its goroutine doesn't outlive the run.
+type Generator struct {
+	name string
+	body []ast.Stmt
+	co *coroutine
+	task *Interpreter // state of the body
+	mu sync.Mutex
+	started bool
+	running bool
+	finished bool
+}
+
+// coroutine is what the goroutine running a generator body holds on to
+type coroutine struct {
+	resumes chan interface{} // values sent to the body, closed to abandon it
+	results chan generatorResult
+}
+
+type generatorResult struct {
+	value interface{}
+	finished bool
+	err error
+}
+
+// closeSignal is sent to a suspended body to make its yield unwind
+type closeSignal struct{}
+
+// errGeneratorClosed unwinds a closed generator's body. Like a return it
+// runs finally blocks but can't be caught.
+var errGeneratorClosed = errors.New("Generator closed")
+
+// newGenerator binds the arguments of a call to a generator function without
+// running any of its body
+func (interp *Interpreter) newGenerator(name string, module *Module, closure *environment.Environment, parameters []token.Token, arity int, body []ast.Stmt, arguments []interface{}) *Generator {
+	env := environment.Local(closure)
+	for i := 0; i < arity; i++ {
+		env.Define(parameters[i].Lexeme, arguments[i])
+	}
+	co := &coroutine{resumes: make(chan interface{}), results: make(chan generatorResult)}
+	task := interp.fork()
+	task.env = env
+	task.global = module.globals
+	task.module = module
+	task.coroutine = co
+	return &Generator{name: name, body: body, co: co, task: task}
+}
+
+func (g *Generator) String() string {
+	return "<generator " + g.name + ">"
+}
+
+// resume runs the body until it yields or finishes and returns the yielded
+// or returned value. message is the value the paused yield evaluates to.
+func (g *Generator) resume(interp *Interpreter, message interface{}) (interface{}, bool, error) {
+	g.mu.Lock()
+	if g.running {
+		g.mu.Unlock()
+		return nil, false, errors.New("Generator already running")
+	}
+	if g.finished {
+		g.mu.Unlock()
+		return nil, true, nil
+	}
+	g.running = true
+	started := g.started
+	g.started = true
+	g.mu.Unlock()
+	// the body continues the call stack of the task resuming it
+	g.task.frames = append(StackTrace(nil), interp.frames...)
+	g.task.ctx = interp.ctx
+	if !started {
+		g.task.scheduler.mu.Lock()
+		g.task.tasks.generators[g] = true
+		g.task.scheduler.mu.Unlock()
+		go g.co.run(g.task, g.body)
+	} else {
+		g.co.resumes <- message
+	}
+	result := <-g.co.results
+	g.mu.Lock()
+	g.running = false
+	if result.finished {
+		g.finish()
+	}
+	g.mu.Unlock()
+	return result.value, result.finished, result.err
+}
+
+// finish marks the generator finished and lets go of the state of its body.
+// g.mu must be held.
+func (g *Generator) finish() {
+	if g.task != nil {
+		g.task.scheduler.mu.Lock()
+		delete(g.task.tasks.generators, g)
+		g.task.scheduler.mu.Unlock()
+	}
+	g.finished = true
+	g.task = nil
+}
+
+// run runs a generator body on its own goroutine
+func (co *coroutine) run(interp *Interpreter, body []ast.Stmt) {
+	result := generatorResult{finished: true}
+	err := interp.executeBlock(body, interp.env)
+	if returned, ok := err.(*returnError); ok {
+		result.value = returned.value
+	} else if err != nil && err != errGeneratorClosed {
+		result.err = interp.locate(err)
+	}
+	co.results <- result
+}
+
+// yield suspends the running generator body and returns the value it is
+// resumed with
+func (interp *Interpreter) yield(value interface{}) (interface{}, error) {
+	co := interp.coroutine
+	co.results <- generatorResult{value: value}
+	message, ok := <-co.resumes
+	if !ok {
+		// the generator was abandoned, nothing waits for this body
+		runtime.Goexit()
+	}
+	if _, ok := message.(closeSignal); ok {
+		return nil, errGeneratorClosed
+	}
+	return message, nil
+}
+
+// send resumes the generator and returns the next value it yields. Once the
+// body finished it throws StopIteration, holding what the body returned
+// the first time.
+func (g *Generator) send(interp *Interpreter, value interface{}) (interface{}, error) {
+	g.mu.Lock()
+	started, finished := g.started, g.finished
+	g.mu.Unlock()
+	if !started && value != nil {
+		return nil, errors.New("Cannot send a value to a generator that has not started")
+	}
+	result, done, err := g.resume(interp, value)
+	if err != nil {
+		return nil, err
+	}
+	if done {
+		stop := newInstance(interp.stopIterationClass)
+		stop.set("message", "Iteration finished")
+		if !finished {
+			stop.set("value", result)
+		}
+		return nil, &RuntimeError{Message: "Uncaught StopIteration: Iteration finished", Value: stop, Thrown: true}
+	}
+	return result, nil
+}
+
+// close finishes a suspended generator, running the finally blocks its body
+// is in
+func (g *Generator) close(interp *Interpreter) error {
+	g.mu.Lock()
+	if !g.started {
+		g.finish()
+	}
+	finished := g.finished
+	g.mu.Unlock()
+	if finished {
+		return nil
+	}
+	_, done, err := g.resume(interp, closeSignal{})
+	if err != nil {
+		return err
+	}
+	if !done {
+		// the body yielded again, abandon it
+		g.mu.Lock()
+		g.finish()
+		g.mu.Unlock()
+		close(g.co.resumes)
+		return errors.New("Generator yielded while closing")
+	}
+	return nil
+}
+
+// get returns the native method name bound to the generator
+func (g *Generator) get(name token.Token) (interface{}, error) {
+	method := func(arity int, fn taskFunc) (interface{}, error) {
+		return &nativeFunction{name: name.Lexeme, arityNum: arity, taskCallable: fn}, nil
+	}
+	switch name.Lexeme {
+	case "next":
+		return method(0, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			return g.send(interp, nil)
+		})
+	case "send":
+		return method(1, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			return g.send(interp, args[0])
+		})
+	case "done":
+		return method(0, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			return g.finished, nil
+		})
+	case "close":
+		return method(0, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			return nil, g.close(interp)
+		})
+	}
+	return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: "Undefined property \"" + name.Lexeme + "\"."}
+}
+
+// generatorIterator runs a generator from a for-in loop
+type generatorIterator struct {
+	interp *Interpreter
+	g *Generator
+}
+
+func (it *generatorIterator) next() (interface{}, bool, error) {
+	value, done, err := it.g.resume(it.interp, nil)
+	if err != nil || done {
+		return nil, false, err
+	}
+	return value, true, nil
+}
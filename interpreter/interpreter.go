@@ -1,286 +1,576 @@
 package interpreter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	//	"github.com/davecgh/go-spew/spew" // to dump structs for debugging
 	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
 	"github.com/singurty/lox/environment"
 	"github.com/singurty/lox/resolver"
 	"github.com/singurty/lox/token"
 )
 
-var env = environment.Global() // keep tracks of current environment
-var global = env // keep track of global environment
-var breakHit bool
-var continueHit bool
-var loopDepth int
-var locals map[ast.Expr]int
+// DefaultMaxCallDepth is the call depth limit used when Options.MaxCallDepth
+// is zero. It keeps deep recursion from exhausting the Go stack.
+const DefaultMaxCallDepth = 10000
 
 type Options struct {
 	PrintOutput io.Writer
+	// MaxSteps limits the number of statements executed per run, 0 means
+	// no limit
+	MaxSteps int
+	// MaxCallDepth limits how deeply calls can nest, 0 means
+	// DefaultMaxCallDepth and a negative value means no limit
+	MaxCallDepth int
+	// MaxInstances limits the number of instances created per run, 0 means
+	// no limit
+	MaxInstances int
+	// Path is the file of the main script. Its imports are resolved
+	// relative to its directory, or to the working directory when empty.
+	Path string
+	// SearchPath lists the directories searched for imported modules that
+	// are not found next to the importing module
+	SearchPath []string
 }
 
-var InterpreterOptions = &Options{PrintOutput: os.Stdout}
+// Interpreter holds all the state needed to run a program. Independent
+// interpreters share nothing and can run concurrently. Within an
+// interpreter, every task started with spawn and every generator body runs
+// with its own Interpreter holding the state of the code it runs, and all
+// of them point to the same shared state.
+type Interpreter struct {
+	env *environment.Environment // keep tracks of current environment
+	global *environment.Environment // globals of the module being run
+	module *Module // module being run
+	importing []*Module // modules being loaded, outermost first, guarded by modulesMu
+	awaiting *Module // module another task is loading that this one waits for, guarded by modulesMu
+	coroutine *coroutine // generator body being run, nil outside generators
+	breakHit bool
+	continueHit bool
+	loopDepth int
+	ctx context.Context
+	frames StackTrace // active calls, innermost last
+	*shared
+}
 
-type runtimeError struct {
-	line int
-	where string
-	message string
+// shared is the state of an interpreter that all its tasks see
+type shared struct {
+	steps int64 // updated atomically
+	instances int64 // updated atomically
+	builtins *environment.Environment // natives, enclosing every module's globals
+	main *Module
+	modulesMu sync.Mutex
+	modules map[string]*Module // loaded modules by absolute path
+	errorClass *class // the Error class of the prelude
+	stopIterationClass *class // thrown by next() methods to end a for-in loop
+	locals sync.Map // depth of resolved variables by ast.Expr
+	options *Options
+	printMu sync.Mutex
+	active int // nested runs, budgets are reset when the outermost one starts
+	tasks tasks
+	scheduler scheduler
 }
 
-type returnError struct {
-	value interface{}
+// fork returns the state of a new task running in the same interpreter. It
+// starts in the module the current task is running.
+func (interp *Interpreter) fork() *Interpreter {
+	return &Interpreter{
+		env: interp.global,
+		global: interp.global,
+		module: interp.module,
+		importing: append([]*Module(nil), interp.importing...),
+		ctx: interp.ctx,
+		shared: interp.shared,
+	}
 }
 
-func Interpret(statements []ast.Stmt, resolver *resolver.Resolver) error {
-	locals = resolver.Locals
-	// define native functions
-	global.Define("clock", &nativeFunction{
-		arityNum: 0,
-		nativeCallable: func(args []interface{}) (interface{}, error) {
-			return time.Now().UnixMilli(), nil
+// New creates an interpreter with its own global environment. A nil options
+// prints to stdout.
+func New(options *Options) *Interpreter {
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.PrintOutput == nil {
+		opts.PrintOutput = os.Stdout
+	}
+	if opts.MaxCallDepth == 0 {
+		opts.MaxCallDepth = DefaultMaxCallDepth
+	}
+	builtins := environment.Global()
+	main := &Module{name: "main", globals: environment.Local(builtins), loaded: make(chan struct{})}
+	interp := &Interpreter{
+		env: main.globals,
+		global: main.globals,
+		module: main,
+		ctx: context.Background(),
+		shared: &shared{
+			builtins: builtins,
+			main: main,
+			modules: make(map[string]*Module),
+			options: &opts,
+			scheduler: scheduler{waiting: make(map[*waiter]bool)},
+			tasks: tasks{generators: make(map[*Generator]bool)},
 		},
+	}
+	if opts.Path != "" {
+		if path, err := filepath.Abs(opts.Path); err == nil {
+			main.name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			main.path = path
+			main.dir = filepath.Dir(path)
+			interp.modules[path] = main
+			interp.importing = []*Module{main}
+		}
+	}
+	interp.runPrelude()
+	// define native functions
+	interp.DefineNative("clock", 0, func(args []interface{}) (interface{}, error) {
+		return time.Now().UnixMilli(), nil
 	})
+	interp.DefineNative("int", 1, toInt)
+	interp.DefineNative("float", 1, toFloatNative)
+	interp.DefineNative("decimal", 1, toDecimalNative)
+	interp.DefineNative("range", Variadic, newRange)
+	// chan and select need the interpreter of the task calling them
+	interp.builtins.Define("chan", &nativeFunction{name: "chan", arityNum: Variadic, taskCallable: newChannel})
+	interp.builtins.Define("select", &nativeFunction{name: "select", arityNum: 1, taskCallable: selectChannels})
+	return interp
+}
+
+// RuntimeError is an error raised while running a script. Trace holds the
+// calls that were active when it happened, innermost last.
+type RuntimeError struct {
+	Line int
+	Where string
+	Message string
+	// Span is the offending source, it is zero when unknown
+	Span diagnostic.Span
+	// File is the absolute path of the module Span refers to, which for the
+	// main script is Options.Path and empty when that isn't set, or the
+	// name given to InterpretSource
+	File string
+	// Value is the value given to throw, nil for errors raised by the
+	// interpreter itself
+	Value interface{}
+	// Thrown is set when the error was raised by throw, which tells a
+	// thrown null apart from errors raised by the interpreter
+	Thrown bool
+	Trace StackTrace
+	located bool // File is set
+}
+
+// Diagnostic describes the error for rendering alongside the source.
+func (err *RuntimeError) Diagnostic() *diagnostic.Diagnostic {
+	return diagnostic.New(diagnostic.RuntimeError, err.Span, err.Message)
+}
+
+type returnError struct {
+	value interface{}
+}
+
+// ErrCancelled matches any *CancelledError with errors.Is.
+var ErrCancelled = errors.New("Execution cancelled")
+
+// CancelledError is returned when the context of a running script is done.
+// It unwraps to the context's error.
+type CancelledError struct {
+	Err error
+}
+
+func (err *CancelledError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrCancelled.Error(), err.Err)
+}
+
+func (err *CancelledError) Unwrap() error {
+	return err.Err
+}
+
+func (err *CancelledError) Is(target error) bool {
+	return target == ErrCancelled
+}
+
+// Interpret runs the statements. Globals defined by earlier calls stay
+// visible, so the same interpreter can back a REPL.
+func (interp *Interpreter) Interpret(statements []ast.Stmt, resolver *resolver.Resolver) error {
+	return interp.InterpretContext(context.Background(), statements, resolver)
+}
+
+// InterpretContext is like Interpret but stops with a *CancelledError once
+// ctx is done. Cancellation is checked before every loop iteration and
+// every call, and ends waits on tasks and channels. A run returns once the
+// tasks it spawned finished.
+func (interp *Interpreter) InterpretContext(ctx context.Context, statements []ast.Stmt, resolver *resolver.Resolver) (err error) {
+	defer interp.begin(ctx)(&err)
+	interp.addLocals(resolver.Locals)
 	for _, statement := range statements {
-		err := execute(statement)
+		err := interp.execute(statement)
 		if err != nil {
+			err = interp.locate(err)
+			// an error can unwind out of loops without resetting their state
+			interp.breakHit = false
+			interp.continueHit = false
+			interp.loopDepth = 0
 			return err
 		}
 	}
 	return nil
 }
 
-func Resolve(expr ast.Expr, depth int) {
-	locals[expr] = depth
+// InterpretSource is like InterpretContext for statements parsed from a
+// source called name, such as one line typed into a REPL. Spans of runtime
+// errors in that code are offsets into that source, so such errors have
+// File set to name, even when a later run calls a function declared there.
+func (interp *Interpreter) InterpretSource(ctx context.Context, name string, statements []ast.Stmt, resolver *resolver.Resolver) error {
+	main := interp.module
+	source := *main
+	source.path = name
+	interp.module = &source
+	defer func() { interp.module = main }()
+	return interp.InterpretContext(ctx, statements, resolver)
 }
 
-func execute(statement ast.Stmt) error {
-	if (breakHit && loopDepth > 0) || (continueHit && loopDepth > 0) {
+func (interp *Interpreter) checkCancelled() error {
+	select {
+	case <-interp.ctx.Done():
+		return &CancelledError{Err: interp.ctx.Err()}
+	default:
 		return nil
 	}
+}
+
+func (interp *Interpreter) Resolve(expr ast.Expr, depth int) {
+	interp.locals.Store(expr, depth)
+}
+
+// addLocals records the variables a resolver resolved
+func (interp *Interpreter) addLocals(locals map[ast.Expr]int) {
+	for expr, depth := range locals {
+		interp.locals.Store(expr, depth)
+	}
+}
+
+// depth returns how many scopes up from the current one the variable expr
+// refers to is declared, ok is false for globals
+func (interp *Interpreter) depth(expr ast.Expr) (distance int, ok bool) {
+	value, ok := interp.locals.Load(expr)
+	if !ok {
+		return 0, false
+	}
+	return value.(int), true
+}
+
+func (interp *Interpreter) execute(statement ast.Stmt) error {
+	if (interp.breakHit && interp.loopDepth > 0) || (interp.continueHit && interp.loopDepth > 0) {
+		return nil
+	}
+	if statement == nil {
+		return nil
+	}
+	err := interp.step(statement)
+	if err != nil {
+		return err
+	}
 	switch s := statement.(type) {
 	case *ast.PrintStmt:
-		value, err := evaluate(s.Expression)
+		value, err := interp.evaluate(s.Expression)
 		if err != nil {
 			return err
 		}
-		if value == nil {
-			value = "null"
-		}
-		fmt.Fprintln(InterpreterOptions.PrintOutput, value)
+		text := stringify(value)
+		// tasks print whole lines
+		interp.printMu.Lock()
+		fmt.Fprintln(interp.options.PrintOutput, text)
+		interp.printMu.Unlock()
 	case *ast.ExprStmt:
-		_, err := evaluate(s.Expression)
+		_, err := interp.evaluate(s.Expression)
 		if err != nil {
 			return err
 		}
 	case *ast.Var:
 		if s.Initializer == nil {
-			err := env.Define(s.Name.Lexeme, nil)
+			err := interp.env.Declare(s.Name.Lexeme)
 			if err != nil {
-				return &runtimeError{line: s.Name.Line, message:err.Error()}
+				return &RuntimeError{Line: s.Name.Line, Span: diagnostic.TokenSpan(s.Name), Message: err.Error()}
 			}
 		} else {
-			value, err := evaluate(s.Initializer)
+			value, err := interp.evaluate(s.Initializer)
 			if err != nil {
 				return err
 			}
-			err = env.Define(s.Name.Lexeme, value)
+			err = interp.env.Define(s.Name.Lexeme, value)
 			if err != nil {
-				return &runtimeError{line: s.Name.Line, message:err.Error()}
+				return &RuntimeError{Line: s.Name.Line, Span: diagnostic.TokenSpan(s.Name), Message: err.Error()}
 			}
 		}
 	case *ast.Block:
-		err := executeBlock(s.Statements, environment.Local(env))
+		err := interp.executeBlock(s.Statements, environment.Local(interp.env))
 		if err != nil {
 			return err
 		}
 	case *ast.If:
-		condition, err := evaluate(s.Condition)
+		condition, err := interp.evaluate(s.Condition)
 		if err != nil {
 			return err
 		}
 		if isTrue(condition) {
-			err := execute(s.ThenBranch)
+			err := interp.execute(s.ThenBranch)
 			if err != nil {
 				return err
 			}
 		} else if s.ElseBranch != nil {
-			err := execute(s.ElseBranch)
+			err := interp.execute(s.ElseBranch)
 			if err != nil {
 				return err
 			}
 		}
 	case *ast.While:
-		condition, err := evaluate(s.Condition)
+		condition, err := interp.evaluate(s.Condition)
 		if err != nil {
 			return err
 		}
-		loopDepth++
+		interp.loopDepth++
 		for isTrue(condition) {
-			err := execute(s.Body)
+			err := interp.checkCancelled()
+			if err != nil {
+				return err
+			}
+			err = interp.execute(s.Body)
 			if err != nil {
 				return err
 			}
-			if breakHit {
-				breakHit = false
+			if interp.breakHit {
+				interp.breakHit = false
 				break
 			}
-			condition, err = evaluate(s.Condition)
+			condition, err = interp.evaluate(s.Condition)
 			if err != nil {
 				return err
 			}
-			if continueHit {
-				continueHit = false
+			if interp.continueHit {
+				interp.continueHit = false
 				continue
 			}
 		}
-		loopDepth--
+		interp.loopDepth--
 	case *ast.For:
-		err := execute(s.Initializer)
+		err := interp.execute(s.Initializer)
 		if err != nil {
 			return err
 		}
-		condition, err := evaluate(s.Condition)
+		condition, err := interp.evaluate(s.Condition)
 		if err != nil {
 			return err
 		}
-		loopDepth++
+		interp.loopDepth++
 		for isTrue(condition) {
-			err := execute(s.Body)
+			err := interp.checkCancelled()
 			if err != nil {
 				return err
 			}
-			if breakHit {
-				breakHit = false
+			err = interp.execute(s.Body)
+			if err != nil {
+				return err
+			}
+			if interp.breakHit {
+				interp.breakHit = false
 				break
 			}
-			_, err = evaluate(s.Increment)
+			_, err = interp.evaluate(s.Increment)
 			if err != nil {
 				return err
 			}
-			condition, err = evaluate(s.Condition)
+			condition, err = interp.evaluate(s.Condition)
 			if err != nil {
 				return err
 			}
-			if continueHit {
-				continueHit = false
-				err := execute(s.Increment)
+			if interp.continueHit {
+				interp.continueHit = false
+				err := interp.execute(s.Increment)
 				if err != nil {
 					return err
 				}
 				continue
 			}
 		}
-		loopDepth--
+		interp.loopDepth--
+	case *ast.ForIn:
+		return interp.executeForIn(s)
 	case *ast.Break:
-		breakHit = true
+		interp.breakHit = true
 	case *ast.Continue:
-		continueHit = true
-	case *ast.Function:
-		function := &userFunction{declaration: s, closure: env}
-		env.Define(s.Name.Lexeme, function)
-	case *ast.Return:
-		value, err := evaluate(s.Value)
+		interp.continueHit = true
+	case *ast.Throw:
+		value, err := interp.evaluate(s.Value)
 		if err != nil {
 			return err
 		}
+		return interp.throw(s, value)
+	case *ast.Try:
+		return interp.executeTry(s)
+	case *ast.Import:
+		m, err := interp.importModule(s.Path)
+		if err != nil {
+			return err
+		}
+		err = interp.env.Define(s.Name.Lexeme, m)
+		if err != nil {
+			return &RuntimeError{Line: s.Name.Line, Span: diagnostic.TokenSpan(s.Name), Message: err.Error()}
+		}
+	case *ast.Function:
+		function := &userFunction{declaration: s, closure: interp.env, module: interp.module}
+		interp.env.Define(s.Name.Lexeme, function)
+	case *ast.Return:
+		var value interface{}
+		if s.Value != nil {
+			var err error
+			value, err = interp.evaluate(s.Value)
+			if err != nil {
+				return err
+			}
+		}
 		return &returnError{value: value}
 	case *ast.Class:
 		// methods might refrence this class
-		env.Define(s.Name.Lexeme, nil)
+		interp.env.Declare(s.Name.Lexeme)
 		var superClass *class
 		if s.SuperClass != nil {
-			superClassVar, err := evaluate(s.SuperClass)
+			superClassVar, err := interp.evaluate(s.SuperClass)
 			if err != nil {
 				return err
 			}
 			var ok bool
 			if superClass, ok = superClassVar.(*class); !ok {
-				return &runtimeError{line: s.SuperClass.Name.Line, where: s.SuperClass.Name.Lexeme, message: "Superclass must be a class."}
+				return &RuntimeError{Line: s.SuperClass.Name.Line, Span: diagnostic.TokenSpan(s.SuperClass.Name), Where: s.SuperClass.Name.Lexeme, Message: "Superclass must be a class."}
 			}
-			env = environment.Local(env)
-			env.Define("super", superClass)
+			interp.env = environment.Local(interp.env)
+			interp.env.Define("super", superClass)
 		}
-		methods := make(map[string]*userFunction)
-		for _, method := range s.Methods {
-			function := &userFunction{declaration: method, closure: env}
-			if method.Name.Lexeme == "init" {
-				function.isInitializer = true
-			}
-			methods[method.Name.Lexeme] = function
+		klass := interp.newClass(s.Name.Lexeme, superClass, s.Methods)
+		klass.metaclass = interp.newClass(s.Name.Lexeme+" metaclass", nil, s.ClassMethods)
+		klass.fields = make(map[string]interface{})
+		if superClass != nil {
+			klass.metaclass.superClass = superClass.metaclass
 		}
-		klass := &class{name: s.Name.Lexeme, superClass: superClass, methods: methods}
 		if s.SuperClass != nil {
-			env = env.Enclosing
+			interp.env = interp.env.Enclosing
 		}
-		env.Assign(s.Name.Lexeme, klass)
+		interp.env.Assign(s.Name.Lexeme, klass)
 	}
 	return nil
 }
 
-func executeBlock(statements []ast.Stmt, environment *environment.Environment) error {
-	previous := env
-	env = environment
+// checkCall checks that callee is a function taking arguments
+func checkCall(callee interface{}, arguments []interface{}, line int, span diagnostic.Span) (callable, error) {
+	function, ok := callee.(callable)
+	if !ok {
+		return nil, &RuntimeError{Line: line, Span: span, Message: "Can only call functions"}
+	}
+	if function.arity() != Variadic && len(arguments) != function.arity() {
+		return nil, &RuntimeError{Line: line, Span: span, Message: "Expected " + strconv.Itoa(function.arity()) + " arguments but got " + strconv.Itoa(len(arguments))}
+	}
+	return function, nil
+}
+
+// callValue calls callee like a call expression on line spanning span
+func (interp *Interpreter) callValue(callee interface{}, arguments []interface{}, line int, span diagnostic.Span) (interface{}, error) {
+	function, err := checkCall(callee, arguments, line, span)
+	if err != nil {
+		return nil, err
+	}
+	err = interp.checkCancelled()
+	if err != nil {
+		return nil, err
+	}
+	err = interp.enterCall(function, line)
+	if err != nil {
+		err.(*RuntimeError).Span = span
+		return nil, err
+	}
+	value, err := function.call(interp, arguments)
+	interp.attachTrace(err)
+	interp.exitCall()
+	if err != nil {
+		if _, ok := function.(*nativeFunction); ok {
+			// errors from host code carry no position, natives that block
+			// return cancellation as is
+			_, cancelled := err.(*CancelledError)
+			if _, ok := err.(*RuntimeError); !ok && !cancelled {
+				return nil, &RuntimeError{Line: line, Span: span, Message: err.Error()}
+			}
+		}
+		// neither do budget errors
+		if runtimeErr, ok := err.(*RuntimeError); ok && runtimeErr.Line == 0 {
+			runtimeErr.Line = line
+			runtimeErr.Span = span
+		}
+	}
+	return value, err
+}
+
+func (interp *Interpreter) executeBlock(statements []ast.Stmt, environment *environment.Environment) error {
+	previous := interp.env
+	interp.env = environment
 	for _, statement := range statements {
-		err := execute(statement)
+		err := interp.execute(statement)
 		if err != nil {
-			env = previous
+			interp.env = previous
 			return err
 		}
 	}
-	env = previous
+	interp.env = previous
 	return nil
 }
 
-func evaluate(node ast.Expr) (interface{}, error) {
+func (interp *Interpreter) evaluate(node ast.Expr) (interface{}, error) {
 	switch n := node.(type) {
 		case *ast.Literal:
 			return n.Value, nil
 		case *ast.Variable:
-			value, err := lookUpVariable(n.Name.Lexeme, n)
+			value, err := interp.lookUpVariable(n.Name.Lexeme, n)
 			if err != nil {
-				return nil, &runtimeError{line: n.Name.Line, message: err.Error()}
+				return nil, &RuntimeError{Line: n.Name.Line, Span: diagnostic.TokenSpan(n.Name), Message: err.Error()}
 			}
 			return value, nil
 		case *ast.Assign:
-			value, err := evaluate(n.Value)
+			value, err := interp.evaluate(n.Value)
 			if err != nil {
 				return nil, err
 			}
-			distance, ok := locals[n]
-			if ok {
-				err = env.AssignAt(distance, n.Name.Lexeme, value)
-			} else {
-				err = global.Assign(n.Name.Lexeme, value)
-			}
+			err = interp.assignVariable(n, n.Name, value)
 			if err != nil {
-				return nil, &runtimeError{line: n.Name.Line, message: err.Error()}
+				return nil, err
 			}
 			return value, nil
 		case *ast.Set:
-			object, err := evaluate(n.Object)
+			object, err := interp.evaluate(n.Object)
 			if err != nil {
 				return nil, err
 			}
-			if object, ok := object.(*Instance); ok {
-				value, err := evaluate(n.Value)
-				if err != nil {
-					return nil, err
-				}
-				object.set(n.Name.Lexeme, value)
-				return value, nil
-			} else {
-				return nil, &runtimeError{line: n.Name.Line, where: n.Name.Lexeme, message: "Only instances have fields."}
+			value, err := interp.evaluate(n.Value)
+			if err != nil {
+				return nil, err
+			}
+			err = interp.setProperty(object, n.Name, value)
+			if err != nil {
+				return nil, err
 			}
+			return value, nil
+		case *ast.Update:
+			return interp.evaluateUpdate(n)
 		case *ast.Grouping:
-			return evaluate(n.Expression)
+			return interp.evaluate(n.Expression)
 		case *ast.Unary:
-			right, err := evaluate(n.Right)
+			right, err := interp.evaluate(n.Right)
 			if err != nil {
 				return nil, err
 			}
@@ -290,86 +580,22 @@ func evaluate(node ast.Expr) (interface{}, error) {
 				if err != nil {
 					return nil, err
 				}
-				return -right.(float64), nil
+				return negate(right), nil
 			case token.BANG:
 				return !isTrue(right), nil
 			}
 		case *ast.Binary:
-			left, err := evaluate(n.Left)
+			left, err := interp.evaluate(n.Left)
 			if err != nil {
 				return nil, err
 			}
-			right, err := evaluate(n.Right)
+			right, err := interp.evaluate(n.Right)
 			if err != nil {
 				return nil, err
 			}
-			switch n.Operator.Type {
-				case token.MINUS:
-					err := checkNumberOperands(n.Operator, right, left)
-					if err != nil {
-						return nil, err
-					}
-					return left.(float64) - right.(float64), nil
-				case token.SLASH:
-					err := checkNumberOperands(n.Operator, right, left)
-					if err != nil {
-						return nil, err
-					}
-					if right.(float64) == 0 {
-						return nil, &runtimeError{line: n.Operator.Line, where: n.Operator.Lexeme, message: "Divide by zero"}
-					}
-					return left.(float64) / right.(float64), nil
-				case token.STAR:
-					err := checkNumberOperands(n.Operator, right, left)
-					if err != nil {
-						return nil, err
-					}
-					return left.(float64) * right.(float64), nil
-				case token.PLUS:
-					switch l := left.(type) {
-						case float64:
-							switch r := right.(type) {
-							case float64:
-								return l + r, nil
-							}
-						case string:
-							switch r := right.(type) {
-							case string:
-								return l + r, nil
-							}
-					}
-					return nil, &runtimeError{line: n.Operator.Line, where: n.Operator.Lexeme, message: "Operands must be eithier numbers or strings"}
-				case token.GREATER:
-					err := checkNumberOperands(n.Operator, right, left)
-					if err != nil {
-						return nil, err
-					}
-					return left.(float64) > right.(float64), nil
-				case token.GREATER_EQUAL:
-					err := checkNumberOperands(n.Operator, right, left)
-					if err != nil {
-						return nil, err
-					}
-					return left.(float64) >= right.(float64), nil
-				case token.LESS:
-					err := checkNumberOperands(n.Operator, right, left)
-					if err != nil {
-						return nil, err
-					}
-					return left.(float64) < right.(float64), nil
-				case token.LESS_EQUAL:
-					err := checkNumberOperands(n.Operator, right, left)
-					if err != nil {
-						return nil, err
-					}
-					return left.(float64) <= right.(float64), nil
-				case token.EQUAL_EQUAL:
-					return isEqual(left, right), nil
-				case token.BANG_EQUAL:
-					return !isEqual(left, right), nil
-			}
+			return binary(n.Operator, left, right)
 		case *ast.Logical:
-			left, err := evaluate(n.Left)
+			left, err := interp.evaluate(n.Left)
 			if err != nil {
 				return nil, err
 			}
@@ -382,111 +608,351 @@ func evaluate(node ast.Expr) (interface{}, error) {
 					return left, nil
 				}
 			}
-			right, err := evaluate(n.Right)
+			right, err := interp.evaluate(n.Right)
 			if err != nil {
 				return nil, err
 			}
 			return right, nil
 		case *ast.Ternary:
-			conditon, err := evaluate(n.Condition)
+			conditon, err := interp.evaluate(n.Condition)
 			if err != nil {
 				return nil, err
 			}
 			if isTrue(conditon) {
-				return evaluate(n.Then)
+				return interp.evaluate(n.Then)
 			} else {
-				return evaluate(n.Else)
+				return interp.evaluate(n.Else)
 			}
 		case *ast.Call:
-			callee, err := evaluate(n.Callee)
+			callee, arguments, err := interp.evaluateCall(n)
+			if err != nil {
+				return nil, err
+			}
+			return interp.callValue(callee, arguments, n.Paren.Line, diagnostic.NodeSpan(n))
+		case *ast.Spawn:
+			callee, arguments, err := interp.evaluateCall(n.Call)
 			if err != nil {
 				return nil, err
 			}
-			arguments := make([]interface{}, 0)
-			for _, arg := range n.Arguments {
-				argument, err := evaluate(arg)
+			task, err := interp.spawn(callee, arguments, n.Call.Paren.Line, diagnostic.NodeSpan(n.Call))
+			if err != nil {
+				return nil, err
+			}
+			return task, nil
+		case *ast.Lambda:
+			return &lambda{declaration: n, closure: interp.env, module: interp.module}, nil
+		case *ast.Yield:
+			var value interface{}
+			if n.Value != nil {
+				var err error
+				value, err = interp.evaluate(n.Value)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return interp.yield(value)
+		case *ast.Get:
+			object, err := interp.evaluate(n.Object)
+			if err != nil {
+				return nil, err
+			}
+			return interp.property(object, n.Name)
+		case *ast.List:
+			elements := make([]interface{}, len(n.Elements))
+			for i, element := range n.Elements {
+				value, err := interp.evaluate(element)
+				if err != nil {
+					return nil, err
+				}
+				elements[i] = value
+			}
+			return newList(elements), nil
+		case *ast.Interpolation:
+			var sb strings.Builder
+			for _, part := range n.Parts {
+				value, err := interp.evaluate(part)
 				if err != nil {
 					return nil, err
 				}
-				arguments = append(arguments, argument)
+				sb.WriteString(stringify(value))
 			}
-			function, ok := callee.(callable)
+			return sb.String(), nil
+		case *ast.Map:
+			m := newMap()
+			for i, keyExpr := range n.Keys {
+				key, err := interp.evaluate(keyExpr)
+				if err != nil {
+					return nil, err
+				}
+				value, err := interp.evaluate(n.Values[i])
+				if err != nil {
+					return nil, err
+				}
+				err = m.setIndex(key, value)
+				if err != nil {
+					return nil, &RuntimeError{Line: n.LeftBrace.Line, Span: diagnostic.NodeSpan(keyExpr), Message: err.Error()}
+				}
+			}
+			return m, nil
+		case *ast.Index:
+			object, err := interp.evaluate(n.Object)
+			if err != nil {
+				return nil, err
+			}
+			index, err := interp.evaluate(n.Index)
+			if err != nil {
+				return nil, err
+			}
+			container, ok := object.(indexable)
 			if !ok {
-				return nil, &runtimeError{line: n.Paren.Line, message: "Can only call functions"}
+				return nil, &RuntimeError{Line: n.RightBracket.Line, Span: diagnostic.NodeSpan(n), Message: "Only lists and maps can be indexed."}
 			}
-			if len(arguments) != function.arity() {
-				return nil, &runtimeError{line: n.Paren.Line, message: "Expected " + strconv.Itoa(function.arity()) + " arguments but got " + strconv.Itoa(len(arguments))}
+			value, err := container.index(index)
+			if err != nil {
+				return nil, &RuntimeError{Line: n.RightBracket.Line, Span: diagnostic.NodeSpan(n.Index), Message: err.Error()}
 			}
-			return function.call(arguments)
-		case *ast.Lambda:
-			return &lambda{declaration: n, closure: env}, nil
-		case *ast.Get:
-			object, err := evaluate(n.Object)
+			return value, nil
+		case *ast.SetIndex:
+			object, err := interp.evaluate(n.Object)
 			if err != nil {
 				return nil, err
 			}
-			if object, ok := object.(*Instance); ok {
-				value, err := object.get(n.Name)
-				return value, err
-			} else {
-				return nil, &runtimeError{line: n.Name.Line, where: n.Name.Lexeme, message: "Only instances have properties."}
+			index, err := interp.evaluate(n.Index)
+			if err != nil {
+				return nil, err
 			}
+			value, err := interp.evaluate(n.Value)
+			if err != nil {
+				return nil, err
+			}
+			container, ok := object.(indexable)
+			if !ok {
+				return nil, &RuntimeError{Line: n.RightBracket.Line, Span: diagnostic.NodeSpan(n.Object), Message: "Only lists and maps can be indexed."}
+			}
+			err = container.setIndex(index, value)
+			if err != nil {
+				return nil, &RuntimeError{Line: n.RightBracket.Line, Span: diagnostic.NodeSpan(n.Index), Message: err.Error()}
+			}
+			return value, nil
 		case *ast.This:
-			return lookUpVariable(n.Keyword.Lexeme, n)
+			return interp.lookUpVariable(n.Keyword.Lexeme, n)
 		case *ast.Super:
-			superClass, err := lookUpVariable(n.Keyword.Lexeme, n)
+			superClass, err := interp.lookUpVariable(n.Keyword.Lexeme, n)
 			if err != nil {
 				return nil, err
 			}
-			method := superClass.(*class).findMethod(n.Method.Lexeme)
+			// "this" is bound in the scope just inside the one holding "super"
+			distance, _ := interp.depth(n)
+			this, err := interp.env.GetAt(distance-1, "this")
+			if err != nil {
+				return nil, &RuntimeError{Line: n.Keyword.Line, Span: diagnostic.NodeSpan(n), Message: err.Error()}
+			}
+			lookup := superClass.(*class)
+			if _, ok := this.(*class); ok {
+				// inside a class method
+				lookup = lookup.metaclass
+			}
+			var method *userFunction
+			if lookup != nil {
+				method = lookup.findMethod(n.Method.Lexeme)
+			}
 			if method == nil {
-				return nil, &runtimeError{line:n.Method.Line, where: n.Method.Lexeme, message: "Undefined method."}
+				return nil, &RuntimeError{Line: n.Method.Line, Span: diagnostic.TokenSpan(n.Method), Where: n.Method.Lexeme, Message: "Undefined method."}
 			}
-			return method, nil
+			return method.Bind(this)
 	}
-	return nil, &runtimeError{message: "Error evaluating expression"}
+	return nil, &RuntimeError{Message: "Error evaluating expression"}
 }
 
-func lookUpVariable(variable string, expr ast.Expr) (interface{}, error) {
-	distance, ok := locals[expr]
+// evaluateCall evaluates the callee and the arguments of a call
+func (interp *Interpreter) evaluateCall(call *ast.Call) (interface{}, []interface{}, error) {
+	callee, err := interp.evaluate(call.Callee)
+	if err != nil {
+		return nil, nil, err
+	}
+	arguments := make([]interface{}, 0)
+	for _, arg := range call.Arguments {
+		argument, err := interp.evaluate(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		arguments = append(arguments, argument)
+	}
+	return callee, arguments, nil
+}
+
+// property reads the property name of object, running getters
+func (interp *Interpreter) property(object interface{}, name token.Token) (interface{}, error) {
+	switch object := object.(type) {
+	case *Instance:
+		return object.get(interp, name)
+	case *List:
+		return object.get(name)
+	case *Map:
+		return object.get(name)
+	case *Module:
+		return object.get(name)
+	case *Generator:
+		return object.get(name)
+	case *Task:
+		return object.get(name)
+	case *Channel:
+		return object.get(name)
+	case *class:
+		return object.get(interp, name)
+	}
+	return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Where: name.Lexeme, Message: "Only instances have properties."}
+}
+
+// setProperty assigns the property name of object, running setters
+func (interp *Interpreter) setProperty(object interface{}, name token.Token, value interface{}) error {
+	var setter *userFunction
+	switch object := object.(type) {
+	case *Instance:
+		setter = object.klass.findSetter(name.Lexeme)
+		if setter == nil {
+			object.set(name.Lexeme, value)
+			return nil
+		}
+	case *class:
+		if object.metaclass != nil {
+			setter = object.metaclass.findSetter(name.Lexeme)
+		}
+		if setter == nil && object.fields != nil {
+			object.setField(name.Lexeme, value)
+			return nil
+		}
+	}
+	if setter == nil {
+		return &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Where: name.Lexeme, Message: "Only instances and classes have fields."}
+	}
+	bound, err := setter.Bind(object)
+	if err != nil {
+		return err
+	}
+	_, err = interp.callAccessor(bound, name, []interface{}{value})
+	return err
+}
+
+func (interp *Interpreter) lookUpVariable(variable string, expr ast.Expr) (interface{}, error) {
+	distance, ok := interp.depth(expr)
 	if ok {
-		return env.GetAt(distance, variable)
+		return interp.env.GetAt(distance, variable)
 	} else {
-		return global.Get(variable)
+		return interp.global.Get(variable)
 	}
 }
 
-func (err *runtimeError) Error() string {
-	if err.where == "" {
-		return fmt.Sprintf("[Line %v] RuntimeError: %v", err.line, err.message)
+func (err *RuntimeError) Error() string {
+	if err.Line == 0 {
+		return fmt.Sprintf("RuntimeError: %v", err.Message)
 	}
-	return fmt.Sprintf("[Line %v] RuntimeError at \"%v\": %v", err.line, err.where, err.message)
+	if err.Where == "" {
+		return fmt.Sprintf("[Line %v] RuntimeError: %v", err.Line, err.Message)
+	}
+	return fmt.Sprintf("[Line %v] RuntimeError at \"%v\": %v", err.Line, err.Where, err.Message)
 }
 
 func (err *returnError) Error() string{
 	return fmt.Sprintf("Return value: %v", err.value)
 }
 
+// binary applies a binary operator to evaluated operands
+func binary(operator token.Token, left, right interface{}) (interface{}, error) {
+	switch operator.Type {
+		case token.MINUS, token.SLASH, token.PERCENT, token.TILDE_SLASH, token.STAR, token.STAR_STAR:
+			err := checkNumberOperands(operator, right, left)
+			if err != nil {
+				return nil, err
+			}
+			return arithmetic(operator, left, right)
+		case token.PLUS:
+			if isNumber(left) && isNumber(right) {
+				return arithmetic(operator, left, right)
+			}
+			if l, ok := left.(string); ok {
+				if r, ok := right.(string); ok {
+					return l + r, nil
+				}
+			}
+			return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Operands must be eithier numbers or strings"}
+		case token.GREATER:
+			err := checkNumberOperands(operator, right, left)
+			if err != nil {
+				return nil, err
+			}
+			c, ok := compareNumbers(left, right)
+			return ok && c > 0, nil
+		case token.GREATER_EQUAL:
+			err := checkNumberOperands(operator, right, left)
+			if err != nil {
+				return nil, err
+			}
+			c, ok := compareNumbers(left, right)
+			return ok && c >= 0, nil
+		case token.LESS:
+			err := checkNumberOperands(operator, right, left)
+			if err != nil {
+				return nil, err
+			}
+			c, ok := compareNumbers(left, right)
+			return ok && c < 0, nil
+		case token.LESS_EQUAL:
+			err := checkNumberOperands(operator, right, left)
+			if err != nil {
+				return nil, err
+			}
+			c, ok := compareNumbers(left, right)
+			return ok && c <= 0, nil
+		case token.EQUAL_EQUAL:
+			return isEqual(left, right), nil
+		case token.BANG_EQUAL:
+			return !isEqual(left, right), nil
+	}
+	return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Unknown operator"}
+}
+
 func checkNumberOperand(operator token.Token, operand interface{}) error {
-	_, ok := operand.(float64)
-	if !ok {
-		return &runtimeError{line: operator.Line, where: operator.Lexeme, message: "Operand must be a number"}
+	if !isNumber(operand) {
+		return &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Operand must be a number"}
 	}
 	return nil
 }
 
 func checkNumberOperands(operator token.Token, operand1, operand2 interface{}) error {
-	_, ok := operand1.(float64)
-	if !ok {
-		return &runtimeError{line: operator.Line, where: operator.Lexeme, message:"Operand must be a number"}
+	if !isNumber(operand1) {
+		return &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Operand must be a number"}
 	}
-	_, ok = operand2.(float64)
-	if !ok {
-		return &runtimeError{line: operator.Line, where: operator.Lexeme, message:"Operand must be a number"}
+	if !isNumber(operand2) {
+		return &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Operand must be a number"}
 	}
 	return nil
 }
 
+// stringify formats a value the way print shows it
+func stringify(value interface{}) string {
+	return stringifyIn(value, nil)
+}
+
+// stringifyIn formats value as an element of the containers in printing, so
+// a container that contains itself is printed as [...] or {...} instead of
+// forever
+func stringifyIn(value interface{}, printing map[interface{}]bool) string {
+	if value == nil {
+		return "null"
+	}
+	if isNumber(value) {
+		return formatNumber(value)
+	}
+	switch value := value.(type) {
+	case *List:
+		return value.format(printing)
+	case *Map:
+		return value.format(printing)
+	}
+	return fmt.Sprint(value)
+}
+
 func isTrue(value interface{}) bool {
 	if value == nil {
 		return false
@@ -496,6 +962,9 @@ func isTrue(value interface{}) bool {
 	return true
 }
 
+// isEqual compares numbers, strings and booleans by value and everything
+// else by identity. An integer equals a float with the same value. Map keys
+// use the same equality.
 func isEqual(left, right interface{}) bool {
 	if left == nil && right == nil {
 		return true
@@ -503,5 +972,9 @@ func isEqual(left, right interface{}) bool {
 	if left == nil {
 		return false
 	}
+	if isNumber(left) && isNumber(right) {
+		c, ok := compareNumbers(left, right)
+		return ok && c == 0
+	}
 	return left == right
 }
@@ -1,11 +1,14 @@
 package interpreter
 
 import (
+	"errors"
+	"math"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/singurty/lox/ast"
-	"github.com/singurty/lox/environment"
 	"github.com/singurty/lox/parser"
 	"github.com/singurty/lox/resolver"
 	"github.com/singurty/lox/scanner"
@@ -17,30 +20,33 @@ type testInputs []struct {
 	expected string
 }
 
-func runTest(source string, t *testing.T) {
-	// reset environment
-	env = environment.Global()
-	global = env
-	locals = make(map[ast.Expr]int)
+func runTest(source string, options *Options, t *testing.T) *Interpreter {
+	interp := New(options)
+	err := runSource(interp, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return interp
+}
+
+// runSource scans, parses, resolves and interprets source with interp
+func runSource(interp *Interpreter, source string) error {
 	scan := scanner.New(source)
 	tokens := scan.ScanTokens()
 	if scan.HadError {
-		t.Fatal("scanner error")
+		return scan.Diagnostics
 	}
 	parse := parser.New(tokens)
+	statements := parse.Parse()
 	if parse.HadError {
-		t.Fatal("parser error")
+		return parse.Diagnostics
 	}
-	statements := parse.Parse()
 	resolver := resolver.NewResolver()
 	err := resolver.Resolve(statements)
 	if err != nil {
-		t.Fatal(err)
-	}
-	err = Interpret(statements, resolver)
-	if err != nil {
-		t.Fatal(err)
+		return err
 	}
+	return interp.Interpret(statements, resolver)
 }
 
 func TestVariable(t *testing.T) {
@@ -51,7 +57,7 @@ func TestVariable(t *testing.T) {
 		var c = "hello";
 		var d = c + " world";
 	`
-	runTest(input, t)
+	interp := runTest(input, nil, t)
 	// Output:
 	// 2
 	// 3
@@ -59,34 +65,40 @@ func TestVariable(t *testing.T) {
 	// 6
 	// hello
 	// hello world
-	a, err := env.Get("a")
+	a, err := interp.global.Get("a")
 	if err != nil {
 		t.Fatalf("Expected variable 'a' in env")
 	}
-	if a.(float64) != 6.0 {
-		t.Errorf("Expected variable 'a' to be 6.0 got %v instead", a.(float64))
+	if a.(int64) != 6 {
+		t.Errorf("Expected variable 'a' to be 6 got %v instead", a.(int64))
 	}
-	b, err := env.Get("b")
+	b, err := interp.global.Get("b")
 	if err != nil {
 		t.Fatalf("Expected variable 'b' in env")
 	}
-	if a.(float64) != 6.0 {
-		t.Errorf("Expected variable 'b' to be 6.0 got %v instead", b.(float64))
+	if a.(int64) != 6 {
+		t.Errorf("Expected variable 'b' to be 6 got %v instead", b.(int64))
 	}
-	c, err := env.Get("c")
+	c, err := interp.global.Get("c")
 	if err != nil {
 		t.Fatalf("Expected variable 'c' in env")
 	}
 	if c != "hello" {
 		t.Errorf("Expected variable 'c' to be \"hello\" got \"%v\" instead", c)
 	}
-	d, err := env.Get("d")
+	d, err := interp.global.Get("d")
 	if err != nil {
 		t.Fatalf("Expected variable 'd' in env")
 	}
 	if d != "hello world" {
 		t.Errorf("Expected variable 'd' to be \"hello world\" got \"%v\" instead", d)
 	}
+	// null can be read, a variable declared without a value can't
+	testInterpreterOutput(`var e = null; print e;`, "null", t)
+	err = runSource(New(nil), `var f; print f;`)
+	if err == nil || !strings.Contains(err.Error(), "Uninitialized variable \"f\"") {
+		t.Errorf("Expected an uninitialized variable error got %v instead", err)
+	}
 }
 
 func TestVariableScope(t *testing.T) {
@@ -672,10 +684,1611 @@ C().test();
 `,
 `
 A method
+`,
+		},
+// methods reached through super are bound to this
+		{
+`
+class Shape {
+  init(name) {
+    this.name = name;
+  }
+
+  describe() {
+    return "a " + this.name;
+  }
+}
+
+class Square < Shape {
+  init(side) {
+    super.init("square");
+    this.side = side;
+  }
+
+  describe() {
+    var parent = super.describe;
+    return parent() + " of side ${this.side}";
+  }
+}
+
+print Square(2).describe();
+`,
+`
+a square of side 2
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+}
+
+func TestList(t *testing.T) {
+	tests := testInputs{
+		{
+`
+var list = [1, "two", [3], null];
+print list;
+print list[1];
+print list[2][0];
+list[0] = list[0] + 10;
+print list[0];
+print [];
+`,
+`
+[1, two, [3], null]
+two
+3
+11
+[]
+`,
+		},
+		{
+`
+var list = [1, 2];
+list.push(3);
+print list.len();
+print list.pop();
+list.insert(0, 0);
+list.insert(3, 9);
+print list;
+print list.slice(1);
+print list.slice(1, 3);
+var push = list.push;
+push(4);
+print list;
+`,
+`
+3
+3
+[0, 1, 2, 9]
+[1, 2, 9]
+[1, 2]
+[0, 1, 2, 9, 4]
+`,
+		},
+		{
+`
+var a = [1];
+a.push(a);
+print a;
+var b = [a, a];
+print b;
+print [b, [b]];
+`,
+`
+[1, [...]]
+[[1, [...]], [1, [...]]]
+[[[1, [...]], [1, [...]]], [[[1, [...]], [1, [...]]]]]
+`,
+		},
+		{
+`
+fun squares(n) {
+	var result = [];
+	for (var i = 0; i < n; i = i + 1) {
+		result.push(i * i);
+	}
+	return result;
+}
+var s = squares(5);
+var total = 0;
+for (var i = 0; i < s.len(); i = i + 1) {
+	total = total + s[i];
+}
+print total;
+`,
+`
+30
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+}
+
+func TestListErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		message string
+	}{
+		{`[1, 2][2];`, "Index 2 out of range"},
+		{`[1, 2][-1];`, "Index -1 out of range"},
+		{`[1, 2][0.5];`, "Index must be an integer, got 0.5"},
+		{`[1, 2]["0"];`, "Index must be a number"},
+		{`var a = 1; a[0] = 2;`, "Only lists and maps can be indexed."},
+		{`[].pop();`, "Cannot pop from an empty list"},
+		{`[1].slice(1, 0);`, "Slice start 1 is after its end 0"},
+		{`[].size();`, "Undefined property \"size\"."},
+	}
+	for _, test := range tests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	tests := testInputs{
+		{
+`
+var m = {};
+m["self"] = m;
+print m;
+var l = [m];
+m["list"] = l;
+print l;
+`,
+`
+{self: {...}}
+[{self: {...}, list: [...]}]
+`,
+		},
+		{
+`
+var ages = {"alice": 30, "bob": 25};
+print ages["alice"];
+ages["carol"] = 41;
+ages["bob"] = ages["bob"] + 1;
+print ages;
+print ages.len();
+print {};
+`,
+`
+30
+{alice: 30, bob: 26, carol: 41}
+3
+{}
+`,
+		},
+		{
+`
+var m = {1: "one", true: "yes", null: "nothing", "1": "string one"};
+print m[1];
+print m[2 - 1];
+print m["1"];
+print m[true];
+print m[null];
+print m.has(1.5);
+print m.remove(true);
+print m.remove(true);
+print m.has(true);
+print m.keys();
+print m.values();
+`,
+`
+one
+one
+string one
+yes
+nothing
+false
+true
+false
+false
+[1, null, 1]
+[one, nothing, string one]
+`,
+		},
+		{
+`
+var counts = {};
+var words = ["a", "b", "a", "c", "a"];
+for (var i = 0; i < words.len(); i = i + 1) {
+	var word = words[i];
+	if (counts.has(word)) {
+		counts[word] = counts[word] + 1;
+	} else {
+		counts[word] = 1;
+	}
+}
+var keys = counts.keys();
+for (var i = 0; i < keys.len(); i = i + 1) {
+	print keys[i];
+	print counts[keys[i]];
+}
+`,
+`
+a
+3
+b
+1
+c
+1
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+}
+
+func TestMapErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		message string
+	}{
+		{`print {"a": 1}["b"];`, "Undefined key \"b\""},
+		{`var m = {}; m[[1]] = 1;`, "Map key must be a number, string, boolean or null, got list"},
+		{`var m = {[]: 1};`, "Map key must be a number, string, boolean or null, got list"},
+		{`var m = {}; m.has({});`, "Map key must be a number, string, boolean or null, got map"},
+		{`var m = {}; m.size();`, "Undefined property \"size\"."},
+	}
+	for _, test := range tests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	tests := testInputs{
+		{
+`
+var x = 41;
+print "value: ${x + 1}";
+print "${x}";
+print "a=${x}, b=${"nested ${x - 1}"}, c=${null}, d=${true}";
+print "list ${[1, 2]} map ${{"k": x}}";
+print "cost: \${x} $x";
+`,
+`
+value: 42
+41
+a=41, b=nested 40, c=null, d=true
+list [1, 2] map {k: 41}
+cost: ${x} $x
+`,
+		},
+		{
+`
+class Point {
+	init(x, y) {
+		this.x = x;
+		this.y = y;
+	}
+	describe() {
+		return "(${this.x}, ${this.y})";
+	}
+}
+print "point ${Point(1, 2).describe()}";
+`,
+`
+point (1, 2)
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := testInputs{
+		{
+`
+try {
+	print "before";
+	throw "boom";
+	print "not reached";
+} catch (e) {
+	print "caught ${e}";
+} finally {
+	print "finally";
+}
+`,
+`
+before
+caught boom
+finally
+`,
+		},
+		{
+`
+try {
+	throw null;
+} catch (e) {
+	print e;
+}
+`,
+`
+null
+`,
+		},
+		{
+`
+fun divide(a, b) {
+	return a / b;
+}
+try {
+	divide(1, 0);
+} catch (e) {
+	print e.message;
+	print e.line;
+	print e.stack;
+}
+try {
+	var a = [];
+	a.missing;
+} catch (e) {
+	print e.message;
+}
+try {
+	divide(1);
+} catch (e) {
+	print e.message;
+}
+`,
+`
+Divide by zero
+3
+[in divide, called at line 6]
+Undefined property "missing".
+Expected 2 arguments but got 1
+`,
+		},
+		{
+`
+class ValidationError < Error {
+	init(field) {
+		super.init("invalid ${field}");
+		this.field = field;
+	}
+}
+fun validate() {
+	throw ValidationError("name");
+}
+try {
+	validate();
+} catch (e) {
+	print e.message;
+	print e.field;
+	print e.line;
+	print e.stack;
+}
+`,
+`
+invalid name
+name
+9
+[in validate, called at line 12]
+`,
+		},
+		{
+`
+fun f() {
+	try {
+		return "try";
+	} finally {
+		print "cleanup";
+	}
+}
+print f();
+for (var i = 0; i < 3; i = i + 1) {
+	try {
+		if (i == 1) {
+			continue;
+		}
+		if (i == 2) {
+			break;
+		}
+		print i;
+	} finally {
+		print "finally ${i}";
+	}
+}
+var caught = "nothing";
+try {
+	try {
+		throw Error("inner");
+	} finally {
+		print "inner finally";
+	}
+} catch (e) {
+	caught = e.message;
+}
+print caught;
+try {
+	while (true) {
+		throw "out of loop";
+	}
+} catch (e) {
+	print e;
+}
+for (var i = 0; i < 2; i = i + 1) {
+	print i;
+}
+`,
+`
+cleanup
+try
+0
+finally 0
+finally 1
+finally 2
+inner finally
+inner
+out of loop
+0
+1
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	tests := []struct {
+		input string
+		message string
+	}{
+		{`throw "boom";`, "[Line 1] RuntimeError: Uncaught boom"},
+		{`throw Error("bad");`, "[Line 1] RuntimeError: Uncaught Error: bad"},
+		{"try {\n  throw 1;\n} catch (e) {\n  throw e + 1;\n}", "[Line 4] RuntimeError: Uncaught 2"},
+		{"try {} finally {\n  throw 3;\n}", "[Line 2] RuntimeError: Uncaught 3"},
+	}
+	for _, test := range tests {
+		err := runSource(New(nil), test.input)
+		if err == nil || err.Error() != test.message {
+			t.Errorf("Expected error %q for %q, got %v", test.message, test.input, err)
+		}
+		var runtimeErr *RuntimeError
+		if errors.As(err, &runtimeErr) && (runtimeErr.Value == nil || !runtimeErr.Thrown) {
+			t.Errorf("Expected the thrown value on the error for %q", test.input)
+		}
+	}
+}
+
+func TestClassMethods(t *testing.T) {
+	tests := testInputs{
+		{
+`
+class Math {
+	class square(n) {
+		return n * n;
+	}
+	class cube(n) {
+		return this.square(n) * n;
+	}
+}
+print Math.square(3);
+print Math.cube(2);
+var square = Math.square;
+print square(4);
+`,
+`
+9
+8
+16
+`,
+		},
+		{
+`
+class Shape {
+	init(name) {
+		this.name = name;
+	}
+	class create(name) {
+		return this(name);
+	}
+	class describe() {
+		return "shape";
+	}
+}
+class Circle < Shape {
+	class describe() {
+		return "circle, a " + super.describe();
+	}
+}
+print Circle.create("c").name;
+print Circle.describe();
+print Shape.describe();
+`,
+`
+c
+circle, a shape
+shape
+`,
+		},
+		{
+`
+class Math {
+	class area(r) {
+		return this.pi * r * r;
+	}
+}
+Math.pi = 3;
+print Math.pi;
+print Math.area(2);
+class Counter {
+	class reset() {
+		this.created = 0;
+	}
+	class make() {
+		this.created++;
+		return this();
+	}
+}
+Counter.reset();
+Counter.make();
+Counter.make();
+print Counter.created;
+class Sub < Counter {}
+print Sub.created;
+Sub.created = 10;
+print Sub.created;
+print Counter.created;
+`,
+`
+3
+12
+2
+2
+10
+2
 `,
 		},
 	}
 	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{`class A { class m() {} } A().m();`, "Undefined property \"m\"."},
+		{`class A { m() {} } A.m();`, "Undefined property \"m\"."},
+		{`class A {} print A.missing;`, "Undefined property \"missing\"."},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestGettersAndSetters(t *testing.T) {
+	tests := testInputs{
+		{
+`
+class Rectangle {
+	init(w, h) {
+		this.w = w;
+		this.h = h;
+	}
+	area {
+		return this.w * this.h;
+	}
+	set width(value) {
+		if (value < 0) {
+			throw Error("width must be positive");
+		}
+		this.w = value;
+	}
+	width {
+		return this.w;
+	}
+}
+var r = Rectangle(2, 3);
+print r.area;
+print r.width = 5;
+print r.width;
+print r.area;
+try {
+	r.width = -1;
+} catch (e) {
+	print e.message;
+}
+print r.w;
+`,
+`
+6
+5
+5
+15
+width must be positive
+5
+`,
+		},
+		{
+`
+class Temperature {
+	init(celsius) {
+		this.celsius = celsius;
+	}
+	fahrenheit {
+		return this.celsius * 9 / 5 + 32;
+	}
+	set fahrenheit(value) {
+		this.celsius = (value - 32) * 5 / 9;
+	}
+	set(x) {
+		return "method named set ${x}";
+	}
+	class freezing {
+		return this(0);
+	}
+}
+class Reading < Temperature {}
+var reading = Reading(100);
+print reading.fahrenheit;
+reading.fahrenheit = 32;
+print reading.celsius;
+print reading.set(1);
+print Temperature.freezing.fahrenheit;
+`,
+`
+212
+0
+method named set 1
+32
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"class A {\n  set x(a, b) {}\n}", "A setter must have exactly one parameter."},
+		{"class A {\n  broken {\n    return this.missing;\n  }\n}\nA().broken;", "[Line 3] RuntimeError: Undefined property \"missing\"."},
+		{"var a = \"text\";\na.x = 1;", "Only instances and classes have fields."},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := testInputs{
+		{
+`
+var a = 1;
+a += 2;
+print a;
+a *= 4;
+a -= 2;
+a /= 5;
+print a;
+a %= 1.5;
+print a;
+var s = "ab";
+s += "c";
+print s;
+print a++;
+print a;
+print ++a;
+print a--;
+print --a;
+{
+	var local = 10;
+	local -= 3;
+	local++;
+	print local;
+}
+`,
+`
+3
+2
+0.5
+abc
+0.5
+1.5
+2.5
+2.5
+0.5
+8
+`,
+		},
+		{
+`
+class Counter {
+	init() {
+		this.count = 0;
+		this._total = 0;
+	}
+	total {
+		return this._total;
+	}
+	set total(value) {
+		print "set total ${value}";
+		this._total = value;
+	}
+}
+var c = Counter();
+c.count += 5;
+c.count++;
+print c.count;
+c.total += 3;
+print c.total++;
+print c.total;
+`,
+`
+6
+set total 3
+set total 4
+3
+4
+`,
+		},
+		{
+`
+var list = [1, 2, 3];
+var calls = 0;
+fun items() {
+	calls++;
+	return list;
+}
+fun at() {
+	calls++;
+	return 1;
+}
+items()[at()] += 10;
+items()[0]++;
+print list;
+print calls;
+var m = {"a": 1};
+m["a"] *= 3;
+print --m["a"];
+print m;
+`,
+`
+[2, 12, 3]
+3
+2
+{a: 2}
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"1++;", "Invalid increment target"},
+		{"var a = 1;\n(a) += 1;", "Invalid assignment target"},
+		{"var s = \"a\";\ns++;", "[Line 2] RuntimeError at \"++\": Operand must be a number"},
+		{"var s = \"a\";\ns -= 1;", "RuntimeError at \"-=\": Operand must be a number"},
+		{"var m = {};\nm[\"x\"] += 1;", "Undefined key \"x\""},
+		{"undefined += 1;", "Undefined variable"},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := testInputs{
+		{
+`
+print 9007199254740993;
+print 9007199254740993 + 1;
+print 7 / 2;
+print 6 / 3;
+print 2 * 1.5;
+print 1 + 0.5;
+print 1 == 1.0;
+print 2 > 1.5;
+print 9007199254740993 > 9007199254740992.0;
+print -9223372036854775807 - 1;
+print 3 ** 39;
+print int(7.9);
+print int(-7.9);
+print int("42") + 1;
+print float(3) / 2;
+print float("2.5");
+var m = {1: "one"};
+print m[1.0];
+m[2.0] = "two";
+print m;
+print [1, 2, 3][1.0];
+var i = 0;
+for (var j = 0; j < 1000000; j = j + 250000) i = j;
+print i;
+`,
+`
+9007199254740993
+9007199254740994
+3.5
+2
+3
+1.5
+true
+true
+true
+-9223372036854775808
+4052555153018976267
+7
+-7
+43
+1.5
+2.5
+one
+{1: one, 2: two}
+2
+750000
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"print 1 ~/ 0;", "Divide by zero"},
+		{"print int(\"4.5\");", "Cannot convert \"4.5\" to int"},
+		{"print int(10.0 ** 400);", "Cannot convert +Inf to int"},
+		{"print int(true);", "Cannot convert boolean to int"},
+		{"print float(\"x\");", "Cannot convert \"x\" to float"},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := testInputs{
+		{
+`
+print 9223372036854775807 + 1;
+print -9223372036854775807 - 2;
+print 4294967296 * 4294967296;
+print 3 ** 40;
+var min = -9223372036854775807 - 1;
+print -min;
+print 9223372036854775808 - 1;
+var big = 2 ** 100;
+print big;
+print big ~/ 3;
+print big % 7;
+print -big ~/ 3;
+print big / 2 ** 99;
+print big + 0.5;
+print big > 9223372036854775807;
+print big == 2 ** 100;
+print big == 1267650600228229401496703205376.0;
+print int(10.0 ** 30);
+print int("123456789012345678901234567890");
+print float(big);
+var m = {};
+m[2 ** 70] = "big";
+print m[1180591620717411303424.0];
+print [1, 2][9223372036854775808 - 9223372036854775807];
+`,
+`
+9223372036854775808
+-9223372036854775809
+18446744073709551616
+12157665459056928801
+9223372036854775808
+9223372036854775807
+1267650600228229401496703205376
+422550200076076467165567735125
+2
+-422550200076076467165567735125
+2
+1.2676506002282294e+30
+true
+true
+true
+1000000000000000019884624838656
+123456789012345678901234567890
+1.2676506002282294e+30
+big
+2
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"print (2 ** 100) % 0;", "RuntimeError at \"%\": Divide by zero"},
+		{"print 3 ** 10000000;", "RuntimeError at \"**\": Integer too large"},
+		{"print [1][2 ** 64];", "Index 18446744073709551616 out of range"},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestDecimals(t *testing.T) {
+	tests := testInputs{
+		{
+`
+var price = decimal("1.10");
+print price;
+print price * 3;
+print decimal("0.1") + decimal("0.2") == decimal("0.3");
+print 0.1 + 0.2 == 0.3;
+print price / 2;
+print decimal("6.00") / 2;
+print decimal(1) / 3;
+print decimal(2) / 3;
+print decimal("-7.5") % 2;
+print decimal("-7.5") ~/ 2;
+print decimal("1.5") ** 2;
+print decimal(2) ** -2;
+print -price;
+print decimal(0.1);
+print decimal("1.0") == 1;
+print decimal("0.5") == 0.5;
+print decimal("0.50") > decimal("0.499");
+print float(price) + 1;
+print int(decimal("-3.99"));
+var m = {0.5: "half", 1: "one"};
+print m[decimal("0.500")];
+print m[decimal("1.00")];
+print "total: ${price * 2}";
+`,
+`
+1.10
+3.30
+true
+false
+0.55
+3.00
+0.3333333333333333333333333333
+0.6666666666666666666666666667
+-1.5
+-3
+2.25
+0.25
+-1.10
+0.1
+true
+true
+true
+2.1
+-3
+half
+one
+total: 2.20
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"print decimal(\"1.1.1\");", "Cannot convert \"1.1.1\" to decimal"},
+		{"print decimal(\"abc\");", "Cannot convert \"abc\" to decimal"},
+		{"print decimal(true);", "Cannot convert boolean to decimal"},
+		{"print decimal(1) + 0.5;", "RuntimeError at \"+\": Cannot mix decimals and floats"},
+		{"print decimal(1) / 0;", "RuntimeError at \"/\": Divide by zero"},
+		{"print decimal(2) ** decimal(\"0.5\");", "Decimal exponent must be an integer"},
+		{"print decimal(\"1.5\") ** 10000000;", "Decimal too large"},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestArithmeticOperators(t *testing.T) {
+	tests := testInputs{
+		{
+`
+print 7 % 3;
+print -7 % 3;
+print 7.5 % 2;
+print 7 ~/ 2;
+print -7 ~/ 2;
+print 7.5 ~/ 2.5;
+print 2 ** 10;
+print -2 ** 2;
+print 2 ** -1;
+print 2 ** 3 ** 2;
+print 1 + 2 * 3 ** 2;
+var n = 10;
+n %= 4;
+print n;
+`,
+`
+1
+-1
+1.5
+3
+-3
+3
+1024
+-4
+0.5
+512
+19
+2
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"print 1 % 0;", "RuntimeError at \"%\": Divide by zero"},
+		{"print 1 ~/ 0;", "RuntimeError at \"~/\": Divide by zero"},
+		{"print 0 ** -1;", "RuntimeError at \"**\": Divide by zero"},
+		{"print \"a\" ** 2;", "Operand must be a number"},
+		{"print 1 ~ 2;", "Unexpected character: ~"},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestForIn(t *testing.T) {
+	tests := testInputs{
+		{
+`
+for (var x in [1, 2, 3]) print x * 10;
+var total = 0;
+for (var key in {"a": 1, "b": 2}) {
+	print key;
+}
+for (var c in "héllo") {
+	if (c == "l") continue;
+	if (c == "o") break;
+	print c;
+}
+for (var i in range(3)) print i;
+for (var i in range(10, 0, -3)) print i;
+print range(2, 5);
+var fns = [];
+for (var i in range(3)) fns.push(fun () { return i; });
+print fns[2]();
+var list = [1];
+for (var x in list) {
+	if (x < 3) list.push(x + 1);
+	print x;
+}
+for (var x in []) print "never";
+var in = "in is still a name";
+print in;
+`,
+`
+10
+20
+30
+a
+b
+h
+é
+0
+1
+2
+10
+7
+4
+1
+range(2, 5)
+2
+1
+2
+3
+in is still a name
+`,
+		},
+		{
+`
+class Countdown {
+	init(from) {
+		this.from = from;
+	}
+	iterator() {
+		return CountdownIterator(this.from);
+	}
+}
+class CountdownIterator {
+	init(current) {
+		this.current = current;
+	}
+	next() {
+		if (this.current == 0) throw StopIteration();
+		this.current--;
+		return this.current + 1;
+	}
+}
+for (var n in Countdown(3)) {
+	for (var m in Countdown(2)) {
+		if (m == 1) break;
+		print "${n}.${m}";
+	}
+}
+class Bag {
+	init() {
+		this.items = ["x", "y"];
+	}
+	iterator() {
+		return this.items;
+	}
+}
+for (var item in Bag()) print item;
+fun first(iterable) {
+	for (var x in iterable) {
+		return x;
+	}
+}
+print first(Countdown(5));
+try {
+	StopIteration().message;
+	throw StopIteration();
+} catch (e) {
+	print e.message;
+}
+`,
+`
+3.2
+2.2
+1.2
+x
+y
+5
+Iteration finished
+`,
+		},
+		{
+`
+var min = -9223372036854775807 - 1;
+var max = 9223372036854775807;
+var n = 0;
+for (var x in range(min, max)) {
+	print x;
+	n++;
+	if (n == 2) break;
+}
+for (var x in range(min, max, max)) print x;
+for (var x in range(max, min, -max)) print x;
+for (var x in range(max - 2, max)) print x;
+`,
+`
+-9223372036854775808
+-9223372036854775807
+-9223372036854775808
+-1
+9223372036854775806
+9223372036854775807
+0
+-9223372036854775807
+9223372036854775805
+9223372036854775806
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	if n := (&Range{start: math.MinInt64, end: math.MaxInt64, step: 1}).Len(); n != math.MaxInt64 {
+		t.Errorf("Expected the length of the widest range to be clamped got %v instead", n)
+	}
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"for (var x in 42) print x;", "Can only iterate over lists, maps, strings, ranges, generators, channels and objects with an iterator() method, got number"},
+		{"class B {}\nclass A { iterator() { return B(); } }\nfor (var x in A()) print x;", "iterator() must return an object with a next() method"},
+		{"class A { iterator() { return this; } next() { throw Error(\"broken\"); } }\nfor (var x in A()) print x;", "Uncaught Error: broken"},
+		{"for (var x in range(0, 1, 0)) print x;", "Range step cannot be zero"},
+		{"range(1.5);", "Range arguments must be integers, got 1.5"},
+		{"for (var x in [1]) { var x = 2; }\nfor (var y in [1]) print x;", "Undefined variable \"x\""},
+		{"for (var x in [1] print x;", "Expected \")\" after iterable"},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := testInputs{
+		{
+`
+fun count(n) {
+	for (var i in range(n)) yield i;
+	return "done";
+}
+var c = count(2);
+print c;
+print c.next();
+print c.next();
+print c.done();
+try {
+	c.next();
+} catch (e) {
+	print e.message;
+	print e.value;
+}
+print c.done();
+for (var x in count(3)) print x * 10;
+fun fib() {
+	var a = 0;
+	var b = 1;
+	while (true) {
+		yield a;
+		var next = a + b;
+		a = b;
+		b = next;
+	}
+}
+for (var n in fib()) {
+	if (n > 20) break;
+	if (n % 2 == 0) continue;
+	print n;
+}
+`,
+`
+<generator count>
+0
+1
+false
+Iteration finished
+done
+true
+0
+10
+20
+1
+1
+3
+5
+13
+`,
+		},
+		{
+`
+fun averager() {
+	var total = 0;
+	var count = 0;
+	var average = 0;
+	while (true) {
+		var value = yield average;
+		total += value;
+		count++;
+		average = total / count;
+	}
+}
+var a = averager();
+a.next();
+print a.send(10);
+print a.send(20);
+fun pairs(list) {
+	for (var x in list) {
+		for (var y in list) {
+			if (x < y) yield "${x}${y}";
+		}
+	}
+}
+for (var p in pairs([1, 2, 3])) print p;
+var squares = fun (n) {
+	for (var i in range(1, n + 1)) yield i * i;
+};
+for (var s in squares(3)) print s;
+class Tree {
+	init(value, children) {
+		this.value = value;
+		this.children = children;
+	}
+	iterator() {
+		yield this.value;
+		for (var child in this.children) {
+			for (var value in child) yield value;
+		}
+	}
+}
+for (var v in Tree(1, [Tree(2, [Tree(3, [])]), Tree(4, [])])) print v;
+fun resource() {
+	try {
+		yield 1;
+		yield 2;
+	} finally {
+		print "cleaned up";
+	}
+}
+var r = resource();
+print r.next();
+r.close();
+print r.done();
+var lazy = fun () {
+	print "started";
+	yield;
+};
+var l = lazy();
+print "created";
+print l.next();
+for (var x in resource()) {
+	print x;
+	break;
+}
+fun pending() {
+	try {
+		yield 1;
+	} finally {
+		print "closed when the run ends";
+	}
+}
+var p = pending();
+print p.next();
+print "last";
+`,
+`
+10
+15
+12
+13
+23
+1
+4
+9
+1
+2
+3
+4
+1
+cleaned up
+true
+created
+started
+null
+1
+cleaned up
+1
+last
+closed when the run ends
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"yield 1;", "Cannot yield from top-level code."},
+		{"class A { init() { yield 1; } }", "Cannot yield from an initializer."},
+		{"fun g() { yield 1; }\ng().send(1);", "Cannot send a value to a generator that has not started"},
+		{"var g;\nfun f() { yield g.next(); }\ng = f();\ng.next();", "Generator already running"},
+		{"fun f() { yield 1; throw Error(\"inside\"); }\nfor (var x in f()) print x;", "Uncaught Error: inside"},
+		{"fun f() { yield 1; }\nf().size();", "Undefined property \"size\"."},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestGeneratorGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	runTest(`
+fun f() {
+	fun gen() {
+		yield 1;
+		yield 2;
+	}
+	var g = gen();
+	g.next();
+	for (var x in gen()) break;
+}
+for (var i in range(1000)) f();
+`, nil, t)
+	// the goroutines of closed generators exit right after their last result
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected at most %v goroutines after the run, got %v", before, after)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := testInputs{
+		{
+`
+fun produce(out, n) {
+	for (var i in range(n)) out.send(i);
+	out.close();
+}
+fun square(in, out) {
+	for (var x in in) out.send(x * x);
+	out.close();
+}
+var numbers = chan();
+var squares = chan();
+spawn produce(numbers, 4);
+spawn square(numbers, squares);
+for (var s in squares) print s;
+print squares.receive();
+fun add(a, b) { return a + b; }
+var task = spawn add(1, 2);
+print task;
+print task.wait();
+print task.done();
+var buffered = chan(2);
+buffered.send("a");
+buffered.send("b");
+buffered.close();
+print buffered.receive();
+print buffered.receive();
+print buffered.receive();
+print buffered;
+`,
+`
+0
+1
+4
+9
+null
+<task add>
+3
+true
+a
+b
+null
+<channel>
+`,
+		},
+		{
+`
+var results = chan(3);
+fun worker(id) { results.send(id * 10); }
+var tasks = [];
+for (var i in range(3)) tasks.push(spawn worker(i));
+for (var task in tasks) task.wait();
+var total = 0;
+for (var i in range(3)) total += results.receive();
+print total;
+var a = chan(1);
+var b = chan(1);
+b.send("from b");
+var chosen = select([a, b]);
+print chosen[0];
+print chosen[1];
+chosen = select([[a, "to a"]]);
+print chosen;
+print a.receive();
+b.close();
+print select([b]);
+fun fail() { throw Error("task failed"); }
+var failing = spawn fail();
+try {
+	failing.wait();
+} catch (e) {
+	print e.message;
+}
+class Counter {
+	init() { this.count = 0; }
+}
+var counter = Counter();
+var done = chan();
+fun increment() {
+	counter.count = 1;
+	done.send(true);
+}
+spawn increment();
+done.receive();
+print counter.count;
+var never = chan();
+try {
+	never.receive();
+} catch (e) {
+	print e.message;
+}
+fun produce(out) {
+	out.send(1);
+	out.send(2);
+}
+var pipe = chan();
+spawn produce(pipe);
+print pipe.receive() + pipe.receive();
+`,
+`
+30
+1
+from b
+[0, null]
+to a
+[0, null]
+task failed
+1
+Deadlock, every task is waiting on a channel or another task
+3
+`,
+		},
+	}
+	testInterpreterOutputs(tests, t)
+
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{"fun f() {}\nspawn f;", "Expected a call after \"spawn\"."},
+		{"var c = chan();\nc.close();\nc.send(1);", "Send on closed channel"},
+		{"var c = chan();\nc.close();\nc.close();", "Channel already closed"},
+		{"var c = chan();\nc.close();\nselect([[c, 1]]);", "Send on closed channel"},
+		{"chan(-1);", "Channel capacity must be a non-negative integer"},
+		{"chan(1, 2);", "Expected 0 or 1 arguments but got 2"},
+		{"select([]);", "select needs at least one case"},
+		{"select([1]);", "Case 0 must be a channel or a [channel, value] list"},
+		{"spawn 1();", "Can only call functions"},
+		{"fun f(a) {}\nspawn f();", "Expected 1 arguments but got 0"},
+		{"fun fail() { throw Error(\"unwaited\"); }\nspawn fail();", "Uncaught Error: unwaited"},
+		{"fun f() {}\n(spawn f()).cancel();", "Undefined property \"cancel\"."},
+		{"var c = chan();\nc.receive();", "[Line 2] RuntimeError: Deadlock, every task is waiting on a channel or another task"},
+		{"var c = chan();\nc.send(1);", "Deadlock"},
+		{"var c = chan();\nfor (var x in c) print x;", "[Line 2] RuntimeError: Deadlock"},
+		{"var c = chan();\nselect([c, [chan(), 1]]);", "Deadlock"},
+		{"var c = chan();\nfun wait() { c.receive(); }\nspawn wait();", "[Line 2] RuntimeError: Deadlock"},
+		{"var c = chan();\nfun wait() { c.receive(); }\nspawn wait().wait();", "Deadlock"},
+		{"var t = 0;\nfun f() { return t.wait(); }\nt = spawn f();\nt.wait();", "Deadlock"},
+	}
+	for _, test := range errorTests {
+		err := runSource(New(nil), test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.message, test.input, err)
+		}
+	}
+}
+
+func TestIndependentInterpreters(t *testing.T) {
+	input := `
+	var total = 0;
+	for (var i = 0; i < 100; i = i + 1) {
+		total = total + i;
+	}
+	print total;
+	`
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testInterpreterOutput(input, "4950", t)
+		}()
+	}
+	wg.Wait()
 }
 
 func testInterpreterOutputs(tests testInputs, t *testing.T) {
@@ -686,8 +2299,7 @@ func testInterpreterOutputs(tests testInputs, t *testing.T) {
 
 func testInterpreterOutput(input string, expected string, t *testing.T) {
 	sb :=  &strings.Builder{}
-	InterpreterOptions.PrintOutput = sb
-	runTest(input, t)
+	runTest(input, &Options{PrintOutput: sb}, t)
 	output := strings.Trim(sb.String(), "\n")
 	expected = strings.Trim(expected, "\n")
 	if output != expected {
@@ -0,0 +1,245 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/environment"
+)
+
+// Range is the sequence of integers from start up to but not including end,
+// counting by step. The range native creates ranges.
+type Range struct {
+	start, end, step int64
+}
+
+// Len returns the number of integers in the range, or math.MaxInt64 for the
+// few ranges holding more.
+func (r *Range) Len() int64 {
+	if n := r.length(); n <= math.MaxInt64 {
+		return int64(n)
+	}
+	return math.MaxInt64
+}
+
+// length counts in uint64 since the distance between two int64 values,
+// and so the length of a range, can be as large as 2^64-1
+func (r *Range) length() uint64 {
+	if r.step > 0 && r.start < r.end {
+		return (uint64(r.end)-uint64(r.start)-1)/uint64(r.step) + 1
+	}
+	if r.step < 0 && r.start > r.end {
+		return (uint64(r.start)-uint64(r.end)-1)/(-uint64(r.step)) + 1
+	}
+	return 0
+}
+
+func (r *Range) String() string {
+	if r.step == 1 {
+		return fmt.Sprintf("range(%v, %v)", r.start, r.end)
+	}
+	return fmt.Sprintf("range(%v, %v, %v)", r.start, r.end, r.step)
+}
+
+// newRange implements the range native: range(end), range(start, end) or
+// range(start, end, step)
+func newRange(args []interface{}) (interface{}, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("Expected 1 to 3 arguments but got %v", len(args))
+	}
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		bound, ok := arg.(int64)
+		if !ok {
+			return nil, fmt.Errorf("Range arguments must be integers, got %v", stringify(arg))
+		}
+		bounds[i] = bound
+	}
+	r := &Range{end: bounds[0], step: 1}
+	if len(bounds) > 1 {
+		r.start, r.end = bounds[0], bounds[1]
+	}
+	if len(bounds) > 2 {
+		r.step = bounds[2]
+	}
+	if r.step == 0 {
+		return nil, errors.New("Range step cannot be zero")
+	}
+	return r, nil
+}
+
+// iterator produces the values a for-in loop visits. next reports false once
+// there are no more values.
+type iterator interface {
+	next() (interface{}, bool, error)
+}
+
+// listIterator sees elements appended while the loop runs
+type listIterator struct {
+	list *List
+	i int
+}
+
+func (it *listIterator) next() (interface{}, bool, error) {
+	it.list.mu.RLock()
+	defer it.list.mu.RUnlock()
+	if it.i >= len(it.list.elements) {
+		return nil, false, nil
+	}
+	it.i++
+	return it.list.elements[it.i-1], true, nil
+}
+
+type sliceIterator struct {
+	values []interface{}
+	i int
+}
+
+func (it *sliceIterator) next() (interface{}, bool, error) {
+	if it.i >= len(it.values) {
+		return nil, false, nil
+	}
+	it.i++
+	return it.values[it.i-1], true, nil
+}
+
+type rangeIterator struct {
+	r *Range
+	i uint64
+}
+
+func (it *rangeIterator) next() (interface{}, bool, error) {
+	if it.i >= it.r.length() {
+		return nil, false, nil
+	}
+	it.i++
+	// wraps around on the way but lands inside the range
+	return int64(uint64(it.r.start) + (it.i-1)*uint64(it.r.step)), true, nil
+}
+
+// methodIterator calls the next method of an object until it throws
+// StopIteration
+type methodIterator struct {
+	interp *Interpreter
+	method *userFunction
+	line int
+	span diagnostic.Span
+}
+
+func (it *methodIterator) next() (interface{}, bool, error) {
+	value, err := it.interp.callValue(it.method, nil, it.line, it.span)
+	if err != nil {
+		var runtimeErr *RuntimeError
+		if errors.As(err, &runtimeErr) && it.interp.instanceOf(runtimeErr.Value, it.interp.stopIterationClass) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// iterate returns an iterator over the values of iterable: the elements of a
+// list, the keys of a map, the characters of a string, the integers of a
+// range, the values a generator yields, the values received from a channel
+// or, for an object with an iterator() method, the values the next() method
+// of the object it returns produces
+func (interp *Interpreter) iterate(iterable interface{}, line int, span diagnostic.Span) (iterator, error) {
+	switch v := iterable.(type) {
+	case *List:
+		return &listIterator{list: v}, nil
+	case *Map:
+		return &sliceIterator{values: v.Keys()}, nil
+	case string:
+		var characters []interface{}
+		for _, c := range v {
+			characters = append(characters, string(c))
+		}
+		return &sliceIterator{values: characters}, nil
+	case *Range:
+		return &rangeIterator{r: v}, nil
+	case *Generator:
+		return &generatorIterator{interp: interp, g: v}, nil
+	case *Channel:
+		return &channelIterator{interp: interp, c: v, line: line, span: span}, nil
+	case *Instance:
+		method := v.klass.findMethod("iterator")
+		if method == nil {
+			break
+		}
+		bound, err := method.Bind(v)
+		if err != nil {
+			return nil, err
+		}
+		it, err := interp.callValue(bound, nil, line, span)
+		if err != nil {
+			return nil, err
+		}
+		if instance, ok := it.(*Instance); ok {
+			next := instance.klass.findMethod("next")
+			if next == nil {
+				return nil, &RuntimeError{Line: line, Span: span, Message: "iterator() must return an object with a next() method"}
+			}
+			bound, err := next.Bind(instance)
+			if err != nil {
+				return nil, err
+			}
+			return &methodIterator{interp: interp, method: bound, line: line, span: span}, nil
+		}
+		// iterator() may hand out a list or another built-in iterable
+		return interp.iterate(it, line, span)
+	}
+	return nil, &RuntimeError{Line: line, Span: span, Message: "Can only iterate over lists, maps, strings, ranges, generators, channels and objects with an iterator() method, got " + typeName(iterable)}
+}
+
+func (interp *Interpreter) executeForIn(stmt *ast.ForIn) (err error) {
+	iterable, err := interp.evaluate(stmt.Iterable)
+	if err != nil {
+		return err
+	}
+	it, err := interp.iterate(iterable, stmt.Keyword.Line, diagnostic.NodeSpan(stmt.Iterable))
+	if err != nil {
+		return err
+	}
+	if generator, ok := it.(*generatorIterator); ok {
+		// leaving the loop early closes the generator so its goroutine
+		// doesn't wait forever
+		defer func() {
+			closeErr := generator.g.close(interp)
+			if err == nil {
+				err = closeErr
+			}
+		}()
+	}
+	interp.loopDepth++
+	for {
+		err := interp.checkCancelled()
+		if err != nil {
+			return err
+		}
+		value, ok, err := it.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		// every iteration gets its own variable so closures capture the
+		// value of their iteration
+		env := environment.Local(interp.env)
+		env.Define(stmt.Name.Lexeme, value)
+		err = interp.executeBlock([]ast.Stmt{stmt.Body}, env)
+		if err != nil {
+			return err
+		}
+		if interp.breakHit {
+			interp.breakHit = false
+			break
+		}
+		interp.continueHit = false
+	}
+	interp.loopDepth--
+	return nil
+}
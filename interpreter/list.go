@@ -0,0 +1,198 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/token"
+)
+
+// indexable values support subscripts such as value[index]
+type indexable interface {
+	index(index interface{}) (interface{}, error)
+	setIndex(index interface{}, value interface{}) error
+}
+
+// List is an ordered collection of lox values.
+type List struct {
+	mu sync.RWMutex // tasks can share lists
+	elements []interface{}
+}
+
+func newList(elements []interface{}) *List {
+	return &List{elements: elements}
+}
+
+// Len returns the number of elements in the list.
+func (l *List) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.elements)
+}
+
+// At returns the element at index.
+func (l *List) At(index int) interface{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.elements[index]
+}
+
+// snapshot returns a copy of the elements
+func (l *List) snapshot() []interface{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	elements := make([]interface{}, len(l.elements))
+	copy(elements, l.elements)
+	return elements
+}
+
+func (l *List) String() string {
+	return l.format(nil)
+}
+
+// format prints the list inside the containers in printing
+func (l *List) format(printing map[interface{}]bool) string {
+	if printing[l] {
+		return "[...]"
+	}
+	if printing == nil {
+		printing = make(map[interface{}]bool)
+	}
+	printing[l] = true
+	defer delete(printing, l)
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i, element := range l.snapshot() {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(stringifyIn(element, printing))
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+func (l *List) index(index interface{}) (interface{}, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i, err := toIndex(index, len(l.elements)-1)
+	if err != nil {
+		return nil, err
+	}
+	return l.elements[i], nil
+}
+
+func (l *List) setIndex(index interface{}, value interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i, err := toIndex(index, len(l.elements)-1)
+	if err != nil {
+		return err
+	}
+	l.elements[i] = value
+	return nil
+}
+
+// get returns the native method name bound to the list
+func (l *List) get(name token.Token) (interface{}, error) {
+	method := func(arity int, fn NativeFunc) (interface{}, error) {
+		return &nativeFunction{name: name.Lexeme, arityNum: arity, nativeCallable: fn}, nil
+	}
+	switch name.Lexeme {
+	case "len":
+		return method(0, func(args []interface{}) (interface{}, error) {
+			return int64(l.Len()), nil
+		})
+	case "push":
+		return method(1, func(args []interface{}) (interface{}, error) {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.elements = append(l.elements, args[0])
+			return nil, nil
+		})
+	case "pop":
+		return method(0, func(args []interface{}) (interface{}, error) {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if len(l.elements) == 0 {
+				return nil, errors.New("Cannot pop from an empty list")
+			}
+			last := l.elements[len(l.elements)-1]
+			l.elements = l.elements[:len(l.elements)-1]
+			return last, nil
+		})
+	case "insert":
+		return method(2, func(args []interface{}) (interface{}, error) {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			i, err := toIndex(args[0], len(l.elements))
+			if err != nil {
+				return nil, err
+			}
+			l.elements = append(l.elements, nil)
+			copy(l.elements[i+1:], l.elements[i:])
+			l.elements[i] = args[1]
+			return nil, nil
+		})
+	case "slice":
+		// the end is optional and defaults to the length of the list
+		return method(Variadic, func(args []interface{}) (interface{}, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return nil, fmt.Errorf("Expected 1 or 2 arguments but got %v", len(args))
+			}
+			l.mu.RLock()
+			defer l.mu.RUnlock()
+			start, err := toIndex(args[0], len(l.elements))
+			if err != nil {
+				return nil, err
+			}
+			end := len(l.elements)
+			if len(args) == 2 {
+				end, err = toIndex(args[1], len(l.elements))
+				if err != nil {
+					return nil, err
+				}
+			}
+			if start > end {
+				return nil, fmt.Errorf("Slice start %v is after its end %v", start, end)
+			}
+			elements := make([]interface{}, end-start)
+			copy(elements, l.elements[start:end])
+			return newList(elements), nil
+		})
+	}
+	return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: "Undefined property \"" + name.Lexeme + "\"."}
+}
+
+// toIndex checks that value is an integer between 0 and max. Floats and
+// decimals without a fractional part are accepted.
+func toIndex(value interface{}, max int) (int, error) {
+	var number int64
+	switch v := value.(type) {
+	case int64:
+		number = v
+	case float64:
+		var ok bool
+		number, ok = floatToInt(v)
+		if !ok {
+			return 0, fmt.Errorf("Index must be an integer, got %v", v)
+		}
+	case *big.Int:
+		return 0, fmt.Errorf("Index %v out of range", v)
+	case *Decimal:
+		if !v.isInteger() {
+			return 0, fmt.Errorf("Index must be an integer, got %v", v)
+		}
+		return toIndex(normalizeInt(v.integer()), max)
+	default:
+		return 0, errors.New("Index must be a number")
+	}
+	if number < 0 || number > int64(max) {
+		return 0, fmt.Errorf("Index %v out of range", number)
+	}
+	return int(number), nil
+}
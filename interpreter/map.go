@@ -0,0 +1,246 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/token"
+)
+
+// Map associates keys with lox values and remembers the order in which keys
+// were inserted. Keys are numbers, strings, booleans or null and compare the
+// same way isEqual does.
+type Map struct {
+	mu sync.RWMutex // tasks can share maps
+	entries map[interface{}]*mapEntry // by the key returned by mapKey
+	order []interface{} // keys returned by mapKey in insertion order
+}
+
+// mapEntry keeps the key an entry was first inserted with
+type mapEntry struct {
+	key interface{}
+	value interface{}
+}
+
+func newMap() *Map {
+	return &Map{entries: make(map[interface{}]*mapEntry)}
+}
+
+// Len returns the number of entries in the map.
+func (m *Map) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.order)
+}
+
+// Keys returns the keys of the map in insertion order.
+func (m *Map) Keys() []interface{} {
+	entries := m.list()
+	keys := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.key
+	}
+	return keys
+}
+
+// Lookup returns the value stored under key and whether it was present.
+func (m *Map) Lookup(key interface{}) (interface{}, bool) {
+	hash, err := mapKey(key)
+	if err != nil {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// list returns a copy of the entries in insertion order
+func (m *Map) list() []mapEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make([]mapEntry, len(m.order))
+	for i, hash := range m.order {
+		entries[i] = *m.entries[hash]
+	}
+	return entries
+}
+
+func (m *Map) String() string {
+	return m.format(nil)
+}
+
+// format prints the map inside the containers in printing
+func (m *Map) format(printing map[interface{}]bool) string {
+	if printing[m] {
+		return "{...}"
+	}
+	if printing == nil {
+		printing = make(map[interface{}]bool)
+	}
+	printing[m] = true
+	defer delete(printing, m)
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, entry := range m.list() {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(stringifyIn(entry.key, printing))
+		sb.WriteString(": ")
+		sb.WriteString(stringifyIn(entry.value, printing))
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+func (m *Map) index(key interface{}) (interface{}, error) {
+	hash, err := mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.entries[hash]
+	if !ok {
+		return nil, fmt.Errorf("Undefined key %v", keyString(key))
+	}
+	return entry.value, nil
+}
+
+func (m *Map) setIndex(key interface{}, value interface{}) error {
+	hash, err := mapKey(key)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.entries[hash]; ok {
+		entry.value = value
+		return nil
+	}
+	m.entries[hash] = &mapEntry{key: key, value: value}
+	m.order = append(m.order, hash)
+	return nil
+}
+
+// remove deletes a key returned by mapKey
+func (m *Map) remove(hash interface{}) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[hash]; !ok {
+		return false
+	}
+	delete(m.entries, hash)
+	for i, h := range m.order {
+		if h == hash {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// get returns the native method name bound to the map
+func (m *Map) get(name token.Token) (interface{}, error) {
+	method := func(arity int, fn NativeFunc) (interface{}, error) {
+		return &nativeFunction{name: name.Lexeme, arityNum: arity, nativeCallable: fn}, nil
+	}
+	switch name.Lexeme {
+	case "len":
+		return method(0, func(args []interface{}) (interface{}, error) {
+			return int64(m.Len()), nil
+		})
+	case "has":
+		return method(1, func(args []interface{}) (interface{}, error) {
+			_, ok := m.Lookup(args[0])
+			if !ok {
+				// the key may be invalid
+				_, err := mapKey(args[0])
+				return false, err
+			}
+			return true, nil
+		})
+	case "remove":
+		// reports whether the key was present
+		return method(1, func(args []interface{}) (interface{}, error) {
+			hash, err := mapKey(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return m.remove(hash), nil
+		})
+	case "keys":
+		return method(0, func(args []interface{}) (interface{}, error) {
+			return newList(m.Keys()), nil
+		})
+	case "values":
+		return method(0, func(args []interface{}) (interface{}, error) {
+			entries := m.list()
+			values := make([]interface{}, len(entries))
+			for i, entry := range entries {
+				values[i] = entry.value
+			}
+			return newList(values), nil
+		})
+	}
+	return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: "Undefined property \"" + name.Lexeme + "\"."}
+}
+
+// keys of big integers and of decimals that no float or integer equals
+type bigKey string
+type decimalKey string
+
+// mapKey checks that value can be used as a map key and returns the key its
+// entry is stored under. Numbers that are equal have the same key whatever
+// their type, so 1, 1.0 and decimal("1.00") are the same key. NaN is
+// rejected because it is not equal to itself.
+func mapKey(value interface{}) (interface{}, error) {
+	switch key := value.(type) {
+	case nil, string, bool, int64:
+		return key, nil
+	case *big.Int:
+		return bigKey(key.String()), nil
+	case float64:
+		if math.IsNaN(key) {
+			return nil, errors.New("Map key cannot be NaN")
+		}
+		if number, ok := floatToInt(key); ok {
+			return number, nil
+		}
+		if key == math.Trunc(key) && !math.IsInf(key, 0) {
+			integer, _ := big.NewFloat(key).Int(nil)
+			return bigKey(integer.String()), nil
+		}
+		return key, nil
+	case *Decimal:
+		if key.isInteger() {
+			integer := normalizeInt(key.integer())
+			if number, ok := integer.(int64); ok {
+				return number, nil
+			}
+			return bigKey(integer.(*big.Int).String()), nil
+		}
+		// a decimal equal to a float is stored under the float
+		if f := key.Float64(); !math.IsInf(f, 0) && new(big.Rat).SetFloat64(f).Cmp(key.Rat()) == 0 {
+			return f, nil
+		}
+		return decimalKey(key.trim(0).String()), nil
+	}
+	return nil, fmt.Errorf("Map key must be a number, string, boolean or null, got %v", typeName(value))
+}
+
+func keyString(key interface{}) string {
+	if str, ok := key.(string); ok {
+		return fmt.Sprintf("%q", str)
+	}
+	return stringify(key)
+}
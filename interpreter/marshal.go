@@ -0,0 +1,415 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// classes created for Go struct and map types, keyed by reflect.Type
+var goClasses sync.Map
+
+// Marshal converts a Go value into a lox value. Booleans, strings and numbers
+// map to their lox counterparts, structs become instances whose fields hold
+// the converted values, maps become lox maps, slices and arrays become lists
+// and functions are wrapped like DefineFunc does. Struct fields may be
+// renamed with a `lox:"name"` tag or skipped with `lox:"-"`. Values that
+// already are lox values are returned unchanged. Values that contain
+// themselves, such as a struct pointing to itself, are an error.
+func Marshal(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return marshalValue(reflect.ValueOf(v))
+}
+
+// reference identifies a pointer, map or slice being converted. Slices
+// sharing an array but not a length are different values.
+type reference struct {
+	pointer uintptr
+	t reflect.Type
+	len int
+}
+
+func marshalValue(v reflect.Value) (interface{}, error) {
+	return marshalIn(v, make(map[reference]bool))
+}
+
+// marshalIn converts v inside the references in converting
+func marshalIn(v reflect.Value, converting map[reference]bool) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func:
+		if v.IsNil() {
+			return nil, nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		ref := reference{pointer: v.Pointer(), t: v.Type()}
+		if v.Kind() == reflect.Slice {
+			ref.len = v.Len()
+		}
+		if converting[ref] {
+			return nil, fmt.Errorf("Cannot convert %v to a lox value, it contains itself", v.Type())
+		}
+		converting[ref] = true
+		defer delete(converting, ref)
+	}
+	if v.CanInterface() {
+		switch value := v.Interface().(type) {
+		case *Instance, *class, *List, *Map, *Module, *Decimal, *Range, *Generator, *Task, *Channel:
+			return value, nil
+		case *big.Int:
+			// copied because the caller may keep changing it
+			return normalizeInt(new(big.Int).Set(value)), nil
+		case *Function:
+			return value.fn, nil
+		case callable:
+			return value, nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return normalizeInt(new(big.Int).SetUint64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Ptr, reflect.Interface:
+		return marshalIn(v.Elem(), converting)
+	case reflect.Struct:
+		instance := newInstance(classFor(v.Type()))
+		for _, field := range structFields(v.Type()) {
+			value, err := marshalIn(v.FieldByIndex(field.index), converting)
+			if err != nil {
+				return nil, fmt.Errorf("field %v: %v", field.name, err)
+			}
+			instance.set(field.name, value)
+		}
+		return instance, nil
+	case reflect.Map:
+		if !keyKind(v.Type().Key().Kind()) {
+			return nil, fmt.Errorf("Cannot convert %v to a lox value, map keys must be booleans, numbers or strings", v.Type())
+		}
+		m := newMap()
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := marshalIn(iter.Key(), converting)
+			if err != nil {
+				return nil, err
+			}
+			value, err := marshalIn(iter.Value(), converting)
+			if err != nil {
+				return nil, fmt.Errorf("key %v: %v", key, err)
+			}
+			err = m.setIndex(key, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]interface{}, v.Len())
+		for i := range elements {
+			value, err := marshalIn(v.Index(i), converting)
+			if err != nil {
+				return nil, fmt.Errorf("index %v: %v", i, err)
+			}
+			elements[i] = value
+		}
+		return newList(elements), nil
+	case reflect.Func:
+		arity, native, err := WrapFunc(v.Type().String(), v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return &nativeFunction{arityNum: arity, nativeCallable: native}, nil
+	}
+	return nil, fmt.Errorf("Cannot convert %v to a lox value", v.Type())
+}
+
+// Unmarshal converts a lox value into the Go value pointed to by target.
+// Instances fill structs field by field, using the same names and tags as
+// Marshal. Maps, and instances when the keys are strings, fill Go maps.
+// Lists fill slices and arrays. An empty interface receives plain Go values:
+// map[string]interface{} for instances and maps with string keys,
+// map[interface{}]interface{} for other maps and []interface{} for lists.
+func Unmarshal(value interface{}, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Unmarshal target must be a non-nil pointer")
+	}
+	return unmarshalValue(value, rv.Elem())
+}
+
+func unmarshalValue(value interface{}, dst reflect.Value) error {
+	t := dst.Type()
+	mismatch := fmt.Errorf("expected %v but got %v", goTypeName(t), typeName(value))
+	if value != nil && t.Kind() != reflect.Interface && reflect.TypeOf(value).AssignableTo(t) {
+		dst.Set(reflect.ValueOf(value))
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		if value == nil {
+			dst.Set(reflect.Zero(t))
+		} else if t.NumMethod() == 0 {
+			dst.Set(reflect.ValueOf(goValue(value)))
+		} else if reflect.TypeOf(value).Implements(t) {
+			dst.Set(reflect.ValueOf(value))
+		} else {
+			return mismatch
+		}
+	case reflect.Ptr:
+		if value == nil {
+			dst.Set(reflect.Zero(t))
+			return nil
+		}
+		if n, ok := value.(int64); ok && t == reflect.TypeOf((*big.Int)(nil)) {
+			// lox keeps big integers that fit in an int64 as int64
+			dst.Set(reflect.ValueOf(big.NewInt(n)))
+			return nil
+		}
+		elem := reflect.New(t.Elem())
+		err := unmarshalValue(value, elem.Elem())
+		if err != nil {
+			return err
+		}
+		dst.Set(elem)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+	case reflect.String:
+		str, ok := value.(string)
+		if !ok {
+			return mismatch
+		}
+		dst.SetString(str)
+	case reflect.Float32, reflect.Float64:
+		if !isNumber(value) {
+			return mismatch
+		}
+		dst.SetFloat(toFloat(value))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var number *big.Int
+		switch v := value.(type) {
+		case int64:
+			number = big.NewInt(v)
+		case float64:
+			n, ok := floatToInt(v)
+			if !ok {
+				return fmt.Errorf("expected an integer but got %v", v)
+			}
+			number = big.NewInt(n)
+		case *big.Int:
+			number = v
+		default:
+			return mismatch
+		}
+		if t.Kind() >= reflect.Uint {
+			if !number.IsUint64() || dst.OverflowUint(number.Uint64()) {
+				return fmt.Errorf("%v does not fit in %v", number, t)
+			}
+			dst.SetUint(number.Uint64())
+		} else {
+			if !number.IsInt64() || dst.OverflowInt(number.Int64()) {
+				return fmt.Errorf("%v does not fit in %v", number, t)
+			}
+			dst.SetInt(number.Int64())
+		}
+	case reflect.Struct:
+		instance, ok := value.(*Instance)
+		if !ok {
+			return mismatch
+		}
+		for _, field := range structFields(t) {
+			fieldValue, ok := instance.Field(field.name)
+			if !ok {
+				continue
+			}
+			err := unmarshalValue(fieldValue, dst.FieldByIndex(field.index))
+			if err != nil {
+				return fmt.Errorf("field %v: %v", field.name, err)
+			}
+		}
+	case reflect.Map:
+		if !keyKind(t.Key().Kind()) {
+			return mismatch
+		}
+		var keys []interface{}
+		var lookup func(key interface{}) interface{}
+		switch container := value.(type) {
+		case *Map:
+			keys = container.Keys()
+			lookup = func(key interface{}) interface{} {
+				value, _ := container.Lookup(key)
+				return value
+			}
+		case *Instance:
+			// instances used as records fill maps with string keys
+			if t.Key().Kind() != reflect.String {
+				return mismatch
+			}
+			fields := container.fieldMap()
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			lookup = func(key interface{}) interface{} { return fields[key.(string)] }
+		default:
+			return mismatch
+		}
+		m := reflect.MakeMapWithSize(t, len(keys))
+		for _, key := range keys {
+			k := reflect.New(t.Key()).Elem()
+			err := unmarshalValue(key, k)
+			if err != nil {
+				return fmt.Errorf("key %v: %v", stringify(key), err)
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			err = unmarshalValue(lookup(key), elem)
+			if err != nil {
+				return fmt.Errorf("key %v: %v", stringify(key), err)
+			}
+			m.SetMapIndex(k, elem)
+		}
+		dst.Set(m)
+	case reflect.Slice, reflect.Array:
+		if value == nil && t.Kind() == reflect.Slice {
+			dst.Set(reflect.Zero(t))
+			return nil
+		}
+		list, ok := value.(*List)
+		if !ok {
+			return mismatch
+		}
+		snapshot := list.snapshot()
+		elements := dst
+		if t.Kind() == reflect.Slice {
+			elements = reflect.MakeSlice(t, len(snapshot), len(snapshot))
+		} else if len(snapshot) != t.Len() {
+			return fmt.Errorf("expected a list of %v elements but got %v", t.Len(), len(snapshot))
+		}
+		for i, element := range snapshot {
+			err := unmarshalValue(element, elements.Index(i))
+			if err != nil {
+				return fmt.Errorf("index %v: %v", i, err)
+			}
+		}
+		dst.Set(elements)
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+// goValue converts a lox value into plain Go values for an empty interface
+func goValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *Instance:
+		fields := v.fieldMap()
+		m := make(map[string]interface{}, len(fields))
+		for key, field := range fields {
+			m[key] = goValue(field)
+		}
+		return m
+	case *Map:
+		// maps with only string keys become map[string]interface{}
+		named := make(map[string]interface{}, v.Len())
+		for _, entry := range v.list() {
+			str, ok := entry.key.(string)
+			if !ok {
+				named = nil
+				break
+			}
+			named[str] = goValue(entry.value)
+		}
+		if named != nil {
+			return named
+		}
+		m := make(map[interface{}]interface{}, v.Len())
+		for _, entry := range v.list() {
+			m[entry.key] = goValue(entry.value)
+		}
+		return m
+	case *List:
+		snapshot := v.snapshot()
+		elements := make([]interface{}, len(snapshot))
+		for i, element := range snapshot {
+			elements[i] = goValue(element)
+		}
+		return elements
+	}
+	return value
+}
+
+// keyKind reports whether Go map keys of kind k can become lox map keys
+func keyKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+type structField struct {
+	name string
+	index []int
+}
+
+// structFields lists the exported fields of t by their lox names. Fields of
+// embedded structs without a tag are promoted like encoding/json does.
+func structFields(t reflect.Type) []structField {
+	fields := make([]structField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("lox")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			for _, promoted := range structFields(field.Type) {
+				promoted.index = append([]int{i}, promoted.index...)
+				fields = append(fields, promoted)
+			}
+			continue
+		}
+		// unexported
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tagName := strings.Split(tag, ",")[0]; tagName != "" {
+			name = tagName
+		}
+		fields = append(fields, structField{name: name, index: []int{i}})
+	}
+	return fields
+}
+
+func classFor(t reflect.Type) *class {
+	if klass, ok := goClasses.Load(t); ok {
+		return klass.(*class)
+	}
+	name := t.Name()
+	if name == "" {
+		name = t.Kind().String()
+	}
+	klass, _ := goClasses.LoadOrStore(t, &class{name: name, methods: make(map[string]*userFunction)})
+	return klass.(*class)
+}
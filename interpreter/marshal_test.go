@@ -0,0 +1,328 @@
+package interpreter
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	City string
+	Zip string `lox:"zip"`
+}
+
+type config struct {
+	Name string `lox:"name"`
+	Port int `lox:"port"`
+	Debug bool
+	Ratio float32
+	Tags []string
+	Address address
+	Backup *address
+	Limits map[string]int
+	secret string
+	Ignored string `lox:"-"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := config{
+		Name: "server",
+		Port: 8080,
+		Debug: true,
+		Ratio: 0.5,
+		Tags: []string{"a", "b"},
+		Address: address{City: "Paris", Zip: "75001"},
+		Limits: map[string]int{"conn": 10},
+		secret: "hidden",
+		Ignored: "ignored",
+	}
+	value, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, ok := value.(*Instance)
+	if !ok {
+		t.Fatalf("Expected an instance got %v instead", value)
+	}
+	if instance.ClassName() != "config" {
+		t.Errorf("Expected class name \"config\" got %v instead", instance.ClassName())
+	}
+	if port, _ := instance.Field("port"); port != int64(8080) {
+		t.Errorf("Expected field port to be 8080 got %v instead", port)
+	}
+	for _, name := range []string{"secret", "Ignored", "Port"} {
+		if _, ok := instance.Field(name); ok {
+			t.Errorf("Expected field %v to be skipped", name)
+		}
+	}
+	var out config
+	err = Unmarshal(value, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.secret = ""
+	in.Ignored = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected %+v got %+v instead", in, out)
+	}
+}
+
+func TestUnmarshalFromScript(t *testing.T) {
+	interp := runTest(`
+	class Address {}
+	class Config {
+		init() {
+			this.name = "worker";
+			this.port = 9000;
+			this.Debug = false;
+			this.Address = Address();
+			this.Address.City = "Oslo";
+			this.Backup = null;
+		}
+	}
+	var result = Config();
+	`, nil, t)
+	value, err := interp.Get("result")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := config{Ratio: 2}
+	err = Unmarshal(value, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := config{Name: "worker", Port: 9000, Ratio: 2, Address: address{City: "Oslo"}}
+	if !reflect.DeepEqual(expected, out) {
+		t.Errorf("Expected %+v got %+v instead", expected, out)
+	}
+	var generic interface{}
+	err = Unmarshal(value, &generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := generic.(map[string]interface{})
+	if !ok || m["name"] != "worker" || m["Address"].(map[string]interface{})["City"] != "Oslo" {
+		t.Errorf("Expected a map of the instance fields got %v instead", generic)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	interp := runTest(`
+	class Config {}
+	var badPort = Config();
+	badPort.port = "80";
+	var fraction = Config();
+	fraction.port = 1.5;
+	`, nil, t)
+	tests := []struct {
+		name string
+		message string
+	}{
+		{"badPort", "field port: expected number but got string"},
+		{"fraction", "field port: expected an integer but got 1.5"},
+	}
+	for _, test := range tests {
+		value, err := interp.Get(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out config
+		err = Unmarshal(value, &out)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q, got %v", test.message, err)
+		}
+	}
+	var out config
+	if err := Unmarshal("text", &out); err == nil || err.Error() != "expected instance but got string" {
+		t.Errorf("Expected type mismatch, got %v", err)
+	}
+	if err := Unmarshal(nil, out); err == nil {
+		t.Errorf("Expected an error for a non-pointer target")
+	}
+}
+
+func TestPassStructToScript(t *testing.T) {
+	sb := &strings.Builder{}
+	interp := runTest(`
+	fun describe(c) {
+		print c.name;
+		print c.Tags;
+		print c.Address.City;
+		return c.port + 1;
+	}
+	`, &Options{PrintOutput: sb}, t)
+	value, err := interp.Call("describe", &config{Name: "api", Port: 41, Tags: []string{"x", "y"}, Address: address{City: "Rome"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != int64(42) {
+		t.Errorf("Expected 42 got %v instead", value)
+	}
+	expected := "api\n[x, y]\nRome\n"
+	if sb.String() != expected {
+		t.Errorf("Expected output to be : %v\nGot: %v\n", expected, sb.String())
+	}
+}
+
+func TestMarshalMap(t *testing.T) {
+	value, err := Marshal(map[int]string{1: "one", 2: "two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := value.(*Map)
+	if !ok {
+		t.Fatalf("Expected a map got %v instead", value)
+	}
+	if one, _ := m.Lookup(1.0); one != "one" {
+		t.Errorf("Expected key 1 to be \"one\" got %v instead", one)
+	}
+	var out map[int]string
+	err = Unmarshal(value, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, map[int]string{1: "one", 2: "two"}) {
+		t.Errorf("Expected the map to round trip got %v instead", out)
+	}
+	if _, err := Marshal(map[[2]int]bool{}); err == nil {
+		t.Errorf("Expected an error for array keys")
+	}
+	if _, err := Marshal(map[float64]bool{math.NaN(): true}); err == nil || err.Error() != "Map key cannot be NaN" {
+		t.Errorf("Expected an error for a NaN key, got %v", err)
+	}
+
+	interp := runTest(`var scores = {"a": 1, "b": 2}; var mixed = {1: true};`, nil, t)
+	scores, _ := interp.Get("scores")
+	var generic interface{}
+	err = Unmarshal(scores, &generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(generic, map[string]interface{}{"a": int64(1), "b": int64(2)}) {
+		t.Errorf("Expected a map with string keys got %v instead", generic)
+	}
+	mixed, _ := interp.Get("mixed")
+	err = Unmarshal(mixed, &generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(generic, map[interface{}]interface{}{int64(1): true}) {
+		t.Errorf("Expected a map with number keys got %v instead", generic)
+	}
+	var names map[string]string
+	if err := Unmarshal(mixed, &names); err == nil || !strings.Contains(err.Error(), "key 1: expected string but got number") {
+		t.Errorf("Expected a key mismatch, got %v", err)
+	}
+}
+
+func TestMarshalNumbers(t *testing.T) {
+	tests := []struct {
+		in interface{}
+		expected interface{}
+	}{
+		{int8(-3), int64(-3)},
+		{uint32(7), int64(7)},
+		{uint64(math.MaxUint64 >> 1), int64(math.MaxInt64)},
+		{float32(1.5), 1.5},
+	}
+	for _, test := range tests {
+		value, err := Marshal(test.in)
+		if err != nil || value != test.expected {
+			t.Errorf("Expected %#v for %#v got %#v (%v) instead", test.expected, test.in, value, err)
+		}
+	}
+	value, err := Marshal(uint64(math.MaxUint64))
+	if n, ok := value.(*big.Int); !ok || err != nil || n.String() != "18446744073709551615" {
+		t.Errorf("Expected a big integer got %#v (%v) instead", value, err)
+	}
+	if err := Unmarshal(value, new(int64)); err == nil || err.Error() != "18446744073709551615 does not fit in int64" {
+		t.Errorf("Expected a range error, got %v", err)
+	}
+	var exact *big.Int
+	if err := Unmarshal(value, &exact); err != nil || exact != value {
+		t.Errorf("Expected the big integer got %v (%v) instead", exact, err)
+	}
+	var small int8
+	if err := Unmarshal(int64(300), &small); err == nil || err.Error() != "300 does not fit in int8" {
+		t.Errorf("Expected a range error, got %v", err)
+	}
+	var unsigned uint
+	if err := Unmarshal(int64(-1), &unsigned); err == nil || err.Error() != "-1 does not fit in uint" {
+		t.Errorf("Expected a range error, got %v", err)
+	}
+	// the largest unsigned integers are big integers in lox
+	var largest uint64
+	if err := Unmarshal(value, &largest); err != nil || largest != math.MaxUint64 {
+		t.Errorf("Expected %v got %v (%v) instead", uint64(math.MaxUint64), largest, err)
+	}
+	var largestUint uint
+	maxUint, _ := Marshal(uint(math.MaxUint))
+	if err := Unmarshal(maxUint, &largestUint); err != nil || largestUint != math.MaxUint {
+		t.Errorf("Expected %v got %v (%v) instead", uint(math.MaxUint), largestUint, err)
+	}
+	if err := Unmarshal(new(big.Int).Lsh(big.NewInt(1), 64), &largest); err == nil || err.Error() != "18446744073709551616 does not fit in uint64" {
+		t.Errorf("Expected a range error, got %v", err)
+	}
+	var whole int
+	if err := Unmarshal(2.0, &whole); err != nil || whole != 2 {
+		t.Errorf("Expected 2 got %v (%v) instead", whole, err)
+	}
+	var fraction float64
+	if err := Unmarshal(int64(3), &fraction); err != nil || fraction != 3 {
+		t.Errorf("Expected 3 got %v (%v) instead", fraction, err)
+	}
+}
+
+func TestMarshalBigRoundTrip(t *testing.T) {
+	type numbers struct {
+		B *big.Int
+		U uint64
+		N uint
+	}
+	tests := []numbers{
+		{big.NewInt(5), 1, 2},
+		{new(big.Int).Lsh(big.NewInt(1), 100), math.MaxUint64, math.MaxUint},
+	}
+	for _, in := range tests {
+		value, err := Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out numbers
+		err = Unmarshal(value, &out)
+		if err != nil || out.B.Cmp(in.B) != 0 || out.U != in.U || out.N != in.N {
+			t.Errorf("Expected %v got %v (%v) instead", in, out, err)
+		}
+	}
+}
+
+type node struct {
+	Value int
+	Next *node
+}
+
+func TestMarshalCycles(t *testing.T) {
+	loop := &node{Value: 1}
+	loop.Next = loop
+	items := make([]interface{}, 1)
+	items[0] = items
+	table := map[string]interface{}{}
+	table["self"] = table
+	for _, in := range []interface{}{loop, items, table} {
+		_, err := Marshal(in)
+		if err == nil || !strings.Contains(err.Error(), "contains itself") {
+			t.Errorf("Expected a cycle error for %T, got %v", in, err)
+		}
+	}
+	// values shared without a cycle are converted twice
+	shared := &node{Value: 2}
+	value, err := Marshal([]*node{shared, shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := value.(*List); list.Len() != 2 || list.At(0) == list.At(1) {
+		t.Errorf("Expected two separate instances got %v instead", list)
+	}
+}
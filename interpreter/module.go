@@ -0,0 +1,301 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/environment"
+	"github.com/singurty/lox/parser"
+	"github.com/singurty/lox/resolver"
+	"github.com/singurty/lox/scanner"
+	"github.com/singurty/lox/token"
+)
+
+// Module is a script with its own global environment. The main script is a
+// module too, modules loaded with import are values whose properties are
+// the module's exports: every top-level name that doesn't start with "_".
+type Module struct {
+	name string
+	path string // absolute path, empty for a main script without Options.Path
+	dir string // directory imports are resolved against
+	globals *environment.Environment
+	// closed once the top-level statements finished, the main script never
+	// finishes
+	loaded chan struct{}
+	loader *Interpreter // task running the top-level statements, guarded by modulesMu
+}
+
+// loading reports whether the top-level statements are still running
+func (m *Module) loading() bool {
+	select {
+	case <-m.loaded:
+		return false
+	default:
+		return true
+	}
+}
+
+// Name returns the file name of the module without its extension.
+func (m *Module) Name() string {
+	return m.name
+}
+
+// Path returns the absolute path the module was loaded from.
+func (m *Module) Path() string {
+	return m.path
+}
+
+// Export returns the value of an exported top-level name.
+func (m *Module) Export(name string) (interface{}, bool) {
+	if strings.HasPrefix(name, "_") {
+		return nil, false
+	}
+	value, err := m.globals.GetAt(0, name)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (m *Module) String() string {
+	return "<module " + m.name + ">"
+}
+
+func (m *Module) get(name token.Token) (interface{}, error) {
+	value, ok := m.Export(name.Lexeme)
+	if !ok {
+		return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: fmt.Sprintf("Module \"%v\" has no export \"%v\".", m.name, name.Lexeme)}
+	}
+	return value, nil
+}
+
+// enterModule makes m the module whose globals are in scope and returns a
+// function restoring the previous one
+func (interp *Interpreter) enterModule(m *Module) func() {
+	previous := interp.module
+	interp.module = m
+	interp.global = m.globals
+	return func() {
+		interp.module = previous
+		interp.global = previous.globals
+	}
+}
+
+// importModule loads the module at path, evaluating it the first time it is
+// imported
+func (interp *Interpreter) importModule(path token.Token) (*Module, error) {
+	fail := func(message string) error {
+		return &RuntimeError{Line: path.Line, Span: diagnostic.TokenSpan(path), Message: message}
+	}
+	file, err := interp.findModule(path.Literal.(string))
+	if err != nil {
+		return nil, fail(err.Error())
+	}
+	var m *Module
+	for m == nil {
+		interp.modulesMu.Lock()
+		cached, ok := interp.modules[file]
+		if !ok {
+			name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+			m = &Module{
+				name: name,
+				path: file,
+				dir: filepath.Dir(file),
+				globals: environment.Local(interp.builtins),
+				loaded: make(chan struct{}),
+				loader: interp,
+			}
+			interp.modules[file] = m
+		}
+		if !ok {
+			interp.modulesMu.Unlock()
+			break
+		}
+		if !cached.loading() {
+			interp.modulesMu.Unlock()
+			return cached, nil
+		}
+		if interp.isImporting(file) {
+			interp.modulesMu.Unlock()
+			return nil, fail("Import cycle: " + interp.importCycle(file))
+		}
+		if cycle := interp.waitCycle(cached, file); cycle != "" {
+			interp.modulesMu.Unlock()
+			return nil, fail("Import cycle: " + cycle)
+		}
+		// another task is loading the module, look again once it is done
+		// because it may fail
+		interp.awaiting = cached
+		interp.modulesMu.Unlock()
+		select {
+		case <-cached.loaded:
+		case <-interp.ctx.Done():
+		}
+		interp.modulesMu.Lock()
+		interp.awaiting = nil
+		interp.modulesMu.Unlock()
+		if err := interp.checkCancelled(); err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		interp.modulesMu.Lock()
+		m.loader = nil
+		interp.modulesMu.Unlock()
+		close(m.loaded)
+	}()
+	// a failed module is not cached so that fixing it and importing again
+	// works
+	forget := func() {
+		interp.modulesMu.Lock()
+		delete(interp.modules, file)
+		interp.modulesMu.Unlock()
+	}
+	source, err := os.ReadFile(file)
+	if err != nil {
+		forget()
+		return nil, fail(fmt.Sprintf("Cannot read module \"%v\": %v", path.Literal, err))
+	}
+	statements, res, err := compile(string(source))
+	if err != nil {
+		forget()
+		return nil, fail(fmt.Sprintf("Error in module \"%v\": %v", path.Literal, err))
+	}
+	// other tasks read the import stack to find cycles
+	interp.modulesMu.Lock()
+	interp.importing = append(interp.importing, m)
+	interp.modulesMu.Unlock()
+	defer func() {
+		interp.modulesMu.Lock()
+		interp.importing = interp.importing[:len(interp.importing)-1]
+		interp.modulesMu.Unlock()
+	}()
+	interp.addLocals(res.Locals)
+	defer interp.enterModule(m)()
+	previous := interp.env
+	interp.env = m.globals
+	defer func() { interp.env = previous }()
+	for _, statement := range statements {
+		err := interp.execute(statement)
+		if err != nil {
+			forget()
+			return nil, interp.locate(err)
+		}
+	}
+	return m, nil
+}
+
+// isImporting reports whether this task is loading the module at file
+func (interp *Interpreter) isImporting(file string) bool {
+	for _, m := range interp.importing {
+		if m.path == file {
+			return true
+		}
+	}
+	return false
+}
+
+// waitCycle returns the chain of imports that would never finish if this
+// task waited for m, loaded by another task, to be imported as file. That
+// happens when the task loading m waits, maybe through other tasks, for a
+// module this task is loading. It returns "" when waiting is fine.
+// modulesMu must be held.
+func (interp *Interpreter) waitCycle(m *Module, file string) string {
+	var chain []string
+	seen := make(map[*Interpreter]bool)
+	for loader := m.loader; loader != nil && !seen[loader]; loader = m.loader {
+		seen[loader] = true
+		// the modules the loader imports from m on
+		for i, loading := range loader.importing {
+			if loading == m {
+				for _, imported := range loader.importing[i:] {
+					chain = append(chain, filepath.Base(imported.path))
+				}
+				break
+			}
+		}
+		if loader == interp {
+			chain = append(chain, filepath.Base(file))
+			return strings.Join(chain, " -> ")
+		}
+		if loader.awaiting == nil {
+			return ""
+		}
+		m = loader.awaiting
+	}
+	return ""
+}
+
+// findModule returns the absolute path of the module imported as name.
+// Paths starting with "./" or "../" are relative to the importing module,
+// other relative paths are looked up in the importing module's directory
+// and then in each directory of the search path.
+func (interp *Interpreter) findModule(name string) (string, error) {
+	var candidates []string
+	if filepath.IsAbs(name) {
+		candidates = []string{name}
+	} else {
+		candidates = append(candidates, filepath.Join(interp.module.dir, name))
+		slash := filepath.ToSlash(name)
+		if !strings.HasPrefix(slash, "./") && !strings.HasPrefix(slash, "../") {
+			for _, dir := range interp.options.SearchPath {
+				candidates = append(candidates, filepath.Join(dir, name))
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("Cannot find module \"%v\"", name)
+}
+
+// importCycle describes the chain of imports that leads back to file
+func (interp *Interpreter) importCycle(file string) string {
+	var chain []string
+	for _, m := range interp.importing {
+		if m.path == file || len(chain) > 0 {
+			chain = append(chain, filepath.Base(m.path))
+		}
+	}
+	chain = append(chain, filepath.Base(file))
+	return strings.Join(chain, " -> ")
+}
+
+// compile scans, parses and resolves source
+func compile(source string) ([]ast.Stmt, *resolver.Resolver, error) {
+	sc := scanner.New(source)
+	tokens := sc.ScanTokens()
+	if sc.HadError {
+		return nil, nil, sc.Diagnostics
+	}
+	p := parser.New(tokens)
+	statements := p.Parse()
+	if p.HadError {
+		return nil, nil, p.Diagnostics
+	}
+	res := resolver.NewResolver()
+	err := res.Resolve(statements)
+	if err != nil {
+		return nil, nil, err
+	}
+	return statements, res, nil
+}
+
+// locate records on a runtime error which module it happened in, unless an
+// inner module already did
+func (interp *Interpreter) locate(err error) error {
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) && !runtimeErr.located {
+		runtimeErr.File = interp.module.path
+		runtimeErr.located = true
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeModules creates files relative to a temporary directory and returns
+// the directory
+func writeModules(files map[string]string, t *testing.T) string {
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(source), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImport(t *testing.T) {
+	dir := writeModules(map[string]string{
+		"main.lox": `
+import "lib/counter.lox" as counter;
+import "lib/counter.lox" as again;
+import "./lib/shapes.lox" as shapes;
+counter.increment();
+again.increment();
+print counter.count();
+print shapes.Square(3).area();
+print shapes.name;
+var name = "main";
+print shapes.describe();
+print counter;
+`,
+		"lib/counter.lox": `
+print "loading counter";
+var _count = 0;
+fun increment() {
+	_count = _count + 1;
+}
+fun count() {
+	return _count;
+}
+`,
+		"lib/shapes.lox": `
+import "counter.lox" as counter;
+var name = "shapes";
+class Square {
+	init(side) {
+		this.side = side;
+	}
+	area() {
+		counter.increment();
+		return this.side * this.side;
+	}
+}
+fun describe() {
+	return "${name} after ${counter.count()} increments";
+}
+`,
+	}, t)
+	sb := &strings.Builder{}
+	interp := New(&Options{PrintOutput: sb, Path: filepath.Join(dir, "main.lox")})
+	source, _ := os.ReadFile(filepath.Join(dir, "main.lox"))
+	err := runSource(interp, string(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "loading counter\n2\n9\nshapes\nshapes after 3 increments\n<module counter>\n"
+	if sb.String() != expected {
+		t.Errorf("Expected output to be : %v\nGot: %v\n", expected, sb.String())
+	}
+}
+
+func TestImportSearchPath(t *testing.T) {
+	libs := writeModules(map[string]string{
+		"util.lox": `fun double(x) { return x * 2; }`,
+	}, t)
+	sb := &strings.Builder{}
+	interp := New(&Options{PrintOutput: sb, SearchPath: []string{t.TempDir(), libs}})
+	err := runSource(interp, `import "util.lox" as util; print util.double(21);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != "42\n" {
+		t.Errorf("Expected 42 got %v instead", sb.String())
+	}
+	// paths starting with ./ are not looked up in the search path
+	err = runSource(interp, `import "./util.lox" as local;`)
+	if err == nil || !strings.Contains(err.Error(), "Cannot find module \"./util.lox\"") {
+		t.Errorf("Expected a missing module error got %v instead", err)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeModules(map[string]string{
+		"a.lox": `import "b.lox" as b;`,
+		"b.lox": `import "a.lox" as a;`,
+		"self.lox": `import "self.lox" as self;`,
+		"broken.lox": `var = 1;`,
+		"failing.lox": "var x = 1;\nprint -\"x\";",
+		"private.lox": `var _secret = 1;`,
+	}, t)
+	tests := []struct {
+		source string
+		message string
+	}{
+		{`import "a.lox" as a;`, "Import cycle: a.lox -> b.lox -> a.lox"},
+		{`import "self.lox" as s;`, "Import cycle: self.lox -> self.lox"},
+		{`import "missing.lox" as m;`, "Cannot find module \"missing.lox\""},
+		{`import "broken.lox" as m;`, "Error in module \"broken.lox\": 1:5: error[syntax-error]: Expected variable name"},
+		{`import "failing.lox" as m;`, "[Line 2] RuntimeError at \"-\": Operand must be a number"},
+		{`import "private.lox" as m; print m._secret;`, "Module \"private\" has no export \"_secret\"."},
+	}
+	for _, test := range tests {
+		interp := New(&Options{Path: filepath.Join(dir, "main.lox")})
+		err := runSource(interp, test.source)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q for %v got %v instead", test.message, test.source, err)
+		}
+	}
+
+	interp := New(&Options{Path: filepath.Join(dir, "main.lox")})
+	err := runSource(interp, `import "failing.lox" as m;`)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.File != filepath.Join(dir, "failing.lox") {
+		t.Errorf("Expected the error to point at failing.lox got %v instead", err)
+	}
+	err = runSource(interp, `print -"main";`)
+	if !errors.As(err, &runtimeErr) || runtimeErr.File != filepath.Join(dir, "main.lox") {
+		t.Errorf("Expected the error to point at main.lox got %v instead", err)
+	}
+}
+
+func TestImportCycleAcrossTasks(t *testing.T) {
+	// each task starts loading one module of the cycle before the other
+	// imports it. The task that finds the cycle fails, the other one loads
+	// the failed module itself and runs into the cycle again.
+	dir := writeModules(map[string]string{
+		"gate.lox": `
+var arrived = chan(3);
+var release = chan();
+`,
+		"a.lox": `
+import "gate.lox" as gate;
+gate.arrived.send("a");
+gate.release.receive();
+import "b.lox" as b;
+`,
+		"b.lox": `
+import "gate.lox" as gate;
+gate.arrived.send("b");
+gate.release.receive();
+import "a.lox" as a;
+`,
+	}, t)
+	sb := &strings.Builder{}
+	interp := New(&Options{Path: filepath.Join(dir, "main.lox"), PrintOutput: sb})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := runSourceContext(ctx, interp, `
+import "gate.lox" as gate;
+fun loadA() { import "a.lox" as a; }
+fun loadB() { import "b.lox" as b; }
+var tasks = [spawn loadA(), spawn loadB()];
+gate.arrived.receive();
+gate.arrived.receive();
+gate.release.close();
+for (var task in tasks) {
+	try {
+		task.wait();
+	} catch (e) {
+		print e.message;
+	}
+}
+`, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	expected := map[string]bool{"Import cycle: a.lox -> b.lox -> a.lox": true, "Import cycle: b.lox -> a.lox -> b.lox": true}
+	if len(lines) != 2 || !expected[lines[0]] || !expected[lines[1]] {
+		t.Errorf("Expected both tasks to report the cycle got %q instead", lines)
+	}
+}
+
+func TestModuleGlobalsAreIsolated(t *testing.T) {
+	dir := writeModules(map[string]string{
+		"lib.lox": `
+var value = "lib";
+fun get() { return value; }
+fun call(f) { return f(); }
+`,
+	}, t)
+	sb := &strings.Builder{}
+	interp := New(&Options{PrintOutput: sb, Path: filepath.Join(dir, "main.lox")})
+	err := runSource(interp, `
+var value = "main";
+import "lib.lox" as lib;
+print lib.get();
+print lib.call(fun () { return value; });
+print clock() > 0;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "lib\nmain\ntrue\n"
+	if sb.String() != expected {
+		t.Errorf("Expected output to be : %v\nGot: %v\n", expected, sb.String())
+	}
+	// Go sees the globals of the main script
+	value, err := interp.Get("value")
+	if err != nil || value != "main" {
+		t.Errorf("Expected main got %v (%v) instead", value, err)
+	}
+}
@@ -0,0 +1,195 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+)
+
+// Variadic is the arity of native functions that accept any number of
+// arguments.
+const Variadic = -1
+
+// NativeFunc is a host function callable from lox. Arguments and the return
+// value are lox values: int64, *big.Int, float64, *Decimal, string, bool, nil
+// or one of the interpreter's own types.
+type NativeFunc func(args []interface{}) (interface{}, error)
+
+// DefineNative registers a host function under name. Natives are visible
+// from the main script and every module. arity is the exact number of
+// arguments expected or Variadic.
+func (interp *Interpreter) DefineNative(name string, arity int, fn NativeFunc) error {
+	if fn == nil {
+		return errors.New("Native function \"" + name + "\" is nil")
+	}
+	if arity < 0 && arity != Variadic {
+		return fmt.Errorf("Invalid arity %v for native function \"%v\"", arity, name)
+	}
+	return interp.builtins.Define(name, &nativeFunction{name: name, arityNum: arity, nativeCallable: fn})
+}
+
+// DefineFunc registers an ordinary Go function as a native.
+// See WrapFunc for how arguments and results are converted.
+func (interp *Interpreter) DefineFunc(name string, fn interface{}) error {
+	arity, native, err := WrapFunc(name, fn)
+	if err != nil {
+		return err
+	}
+	return interp.DefineNative(name, arity, native)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// WrapFunc uses reflection to turn a Go function such as
+// func(string, float64) (bool, error) into a NativeFunc. Arguments are
+// converted with Unmarshal, except that interface{} parameters receive the
+// raw lox value, and results with Marshal. The function may return nothing,
+// a value, an error, or a value and an error. Go variadic functions become
+// Variadic natives.
+func WrapFunc(name string, fn interface{}) (int, NativeFunc, error) {
+	fnValue := reflect.ValueOf(fn)
+	if fnValue.Kind() != reflect.Func || fnValue.IsNil() {
+		return 0, nil, fmt.Errorf("Native function \"%v\" must be a Go function, got %T", name, fn)
+	}
+	fnType := fnValue.Type()
+	numOut := fnType.NumOut()
+	if numOut > 2 || (numOut == 2 && fnType.Out(1) != errorType) {
+		return 0, nil, fmt.Errorf("Native function \"%v\" must return at most a value and an error", name)
+	}
+	for i := 0; i < fnType.NumIn(); i++ {
+		paramType := fnType.In(i)
+		if fnType.IsVariadic() && i == fnType.NumIn()-1 {
+			paramType = paramType.Elem()
+		}
+		if !convertible(paramType) {
+			return 0, nil, fmt.Errorf("Native function \"%v\" has unsupported parameter type %v", name, paramType)
+		}
+	}
+	fixed := fnType.NumIn()
+	arity := fixed
+	if fnType.IsVariadic() {
+		fixed--
+		arity = Variadic
+	}
+	native := func(args []interface{}) (interface{}, error) {
+		if len(args) < fixed {
+			return nil, fmt.Errorf("Expected at least %v arguments but got %v", fixed, len(args))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var paramType reflect.Type
+			if i >= fixed && fnType.IsVariadic() {
+				paramType = fnType.In(fixed).Elem()
+			} else {
+				paramType = fnType.In(i)
+			}
+			value, err := argument(arg, paramType)
+			if err != nil {
+				return nil, fmt.Errorf("Argument %v of \"%v\": %v", i+1, name, err.Error())
+			}
+			in[i] = value
+		}
+		out := fnValue.Call(in)
+		if numOut == 0 {
+			return nil, nil
+		}
+		last := out[numOut-1]
+		if last.Type() == errorType {
+			if !last.IsNil() {
+				return nil, last.Interface().(error)
+			}
+			if numOut == 1 {
+				return nil, nil
+			}
+		}
+		return marshalValue(out[0])
+	}
+	return arity, native, nil
+}
+
+// convertible reports whether lox values can be converted to t
+func convertible(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Uintptr, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Map:
+		return keyKind(t.Key().Kind())
+	}
+	return true
+}
+
+// argument converts a lox argument for a parameter of type t. Interface
+// parameters receive the raw lox value.
+func argument(arg interface{}, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface {
+		if arg == nil {
+			return reflect.Zero(t), nil
+		}
+		value := reflect.ValueOf(arg)
+		if !value.Type().Implements(t) {
+			return reflect.Value{}, fmt.Errorf("expected %v but got %v", goTypeName(t), typeName(arg))
+		}
+		return value.Convert(t), nil
+	}
+	value := reflect.New(t).Elem()
+	err := unmarshalValue(arg, value)
+	return value, err
+}
+
+func goTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Struct:
+		return "instance"
+	case reflect.Map:
+		return "map"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Ptr:
+		return goTypeName(t.Elem())
+	}
+	return t.String()
+}
+
+// typeName is the lox name of a value's type used in error messages
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case int64, float64, *big.Int, *Decimal:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case *class:
+		return "class"
+	case *Instance:
+		return "instance"
+	case *List:
+		return "list"
+	case *Map:
+		return "map"
+	case *Module:
+		return "module"
+	case *Range:
+		return "range"
+	case *Generator:
+		return "generator"
+	case *Task:
+		return "task"
+	case *Channel:
+		return "channel"
+	case callable:
+		return "function"
+	}
+	return fmt.Sprintf("%T", value)
+}
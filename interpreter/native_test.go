@@ -0,0 +1,116 @@
+package interpreter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDefineNative(t *testing.T) {
+	sb := &strings.Builder{}
+	interp := New(&Options{PrintOutput: sb})
+	err := interp.DefineNative("double", 1, func(args []interface{}) (interface{}, error) {
+		return args[0].(int64) * 2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = interp.DefineNative("count", Variadic, func(args []interface{}) (interface{}, error) {
+		return float64(len(args)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runSource(interp, `
+	print double(21);
+	print count();
+	print count(1, "two", null);
+	print double;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "42\n0\n3\n<native fun double>\n"
+	if sb.String() != expected {
+		t.Errorf("Expected output to be : %v\nGot: %v\n", expected, sb.String())
+	}
+	err = runSource(interp, `double(1, 2);`)
+	if err == nil || !strings.Contains(err.Error(), "Expected 1 arguments but got 2") {
+		t.Errorf("Expected arity error, got %v", err)
+	}
+}
+
+func TestDefineFunc(t *testing.T) {
+	sb := &strings.Builder{}
+	interp := New(&Options{PrintOutput: sb})
+	funcs := map[string]interface{}{
+		"repeat": func(s string, n int) string {
+			return strings.Repeat(s, n)
+		},
+		"longer": func(s string, n float64) (bool, error) {
+			return float64(len(s)) > n, nil
+		},
+		"sum": func(numbers ...float64) float64 {
+			total := 0.0
+			for _, number := range numbers {
+				total += number
+			}
+			return total
+		},
+		"fail": func() error {
+			return errors.New("host failure")
+		},
+		"identity": func(v interface{}) interface{} {
+			return v
+		},
+	}
+	for name, fn := range funcs {
+		if err := interp.DefineFunc(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := runSource(interp, `
+	print repeat("ab", 3);
+	print longer("hello", 3);
+	print sum(1, 2, 3.5);
+	print identity(null);
+	print identity("same");
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "ababab\ntrue\n6.5\nnull\nsame\n"
+	if sb.String() != expected {
+		t.Errorf("Expected output to be : %v\nGot: %v\n", expected, sb.String())
+	}
+	errorTests := []struct {
+		input string
+		message string
+	}{
+		{`repeat(1, 2);`, "Argument 1 of \"repeat\": expected string but got number"},
+		{`repeat("a", 1.5);`, "Argument 2 of \"repeat\": expected an integer but got 1.5"},
+		{`sum(1, true);`, "Argument 2 of \"sum\": expected number but got boolean"},
+		{`fail();`, "host failure"},
+	}
+	for _, test := range errorTests {
+		err := runSource(interp, test.input)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected error containing %q, got %v", test.message, err)
+		}
+	}
+}
+
+func TestDefineFuncInvalid(t *testing.T) {
+	interp := New(nil)
+	invalid := []interface{}{
+		nil,
+		42,
+		func() (int, int) { return 0, 0 },
+		func(ch chan int) {},
+	}
+	for _, fn := range invalid {
+		if err := interp.DefineFunc("bad", fn); err == nil {
+			t.Errorf("Expected an error wrapping %T", fn)
+		}
+	}
+}
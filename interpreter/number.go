@@ -0,0 +1,351 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/token"
+)
+
+// Integers are int64, or *big.Int when they don't fit in an int64: integer
+// arithmetic that overflows continues with big integers and results that fit
+// are converted back, so every integer has a single representation. Other
+// numbers are float64 or exact *Decimal values. An operation with a float
+// operand or a division with / gives a float, an operation with a decimal
+// and an integer gives a decimal. Decimals and floats can't be mixed.
+
+// maxIntegerBits limits the size of powers so that a typo can't exhaust
+// memory
+const maxIntegerBits = 1 << 20
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int64, float64, *big.Int, *Decimal:
+		return true
+	}
+	return false
+}
+
+// toFloat converts a number to float64
+func toFloat(value interface{}) float64 {
+	switch number := value.(type) {
+	case int64:
+		return float64(number)
+	case float64:
+		return number
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(number).Float64()
+		return f
+	case *Decimal:
+		return number.Float64()
+	}
+	return math.NaN()
+}
+
+// toBig converts an integer to a big integer
+func toBig(value interface{}) *big.Int {
+	if number, ok := value.(int64); ok {
+		return big.NewInt(number)
+	}
+	return value.(*big.Int)
+}
+
+// normalizeInt returns n as an int64 if it fits
+func normalizeInt(n *big.Int) interface{} {
+	if n.IsInt64() {
+		return n.Int64()
+	}
+	return n
+}
+
+// toRat converts a finite number to a fraction
+func toRat(value interface{}) (*big.Rat, bool) {
+	switch number := value.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(number), true
+	case *big.Int:
+		return new(big.Rat).SetInt(number), true
+	case *Decimal:
+		return number.Rat(), true
+	case float64:
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(number), true
+	}
+	return nil, false
+}
+
+// floatToInt converts a float to an integer if it has no fractional part and
+// is in range
+func floatToInt(number float64) (int64, bool) {
+	if number != math.Trunc(number) || number < math.MinInt64 || number >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(number), true
+}
+
+// arithmetic applies an arithmetic operator to two numbers
+func arithmetic(operator token.Token, left, right interface{}) (interface{}, error) {
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	if lok && rok && operator.Type != token.SLASH && !(operator.Type == token.STAR_STAR && r < 0) {
+		return integerArithmetic(operator, l, r)
+	}
+	_, lfloat := left.(float64)
+	_, rfloat := right.(float64)
+	_, ldecimal := left.(*Decimal)
+	_, rdecimal := right.(*Decimal)
+	switch {
+	case (lfloat && rdecimal) || (ldecimal && rfloat):
+		return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Cannot mix decimals and floats, convert one with decimal() or float()"}
+	case ldecimal || rdecimal:
+		return decimalArithmetic(operator, toDecimal(left), toDecimal(right))
+	case lfloat || rfloat || operator.Type == token.SLASH:
+		return floatArithmetic(operator, toFloat(left), toFloat(right))
+	case operator.Type == token.STAR_STAR && toBig(right).Sign() < 0:
+		return floatArithmetic(operator, toFloat(left), toFloat(right))
+	}
+	return bigArithmetic(operator, toBig(left), toBig(right))
+}
+
+// integerArithmetic applies an operator to two int64 values and continues
+// with big integers when the result overflows
+func integerArithmetic(operator token.Token, l, r int64) (interface{}, error) {
+	overflow := func() (interface{}, error) {
+		return bigArithmetic(operator, big.NewInt(l), big.NewInt(r))
+	}
+	switch operator.Type {
+	case token.PLUS:
+		if (r > 0 && l > math.MaxInt64-r) || (r < 0 && l < math.MinInt64-r) {
+			return overflow()
+		}
+		return l + r, nil
+	case token.MINUS:
+		if (r < 0 && l > math.MaxInt64+r) || (r > 0 && l < math.MinInt64+r) {
+			return overflow()
+		}
+		return l - r, nil
+	case token.STAR:
+		product, ok := multiply(l, r)
+		if !ok {
+			return overflow()
+		}
+		return product, nil
+	case token.PERCENT, token.TILDE_SLASH:
+		if r == 0 {
+			return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Divide by zero"}
+		}
+		if operator.Type == token.PERCENT {
+			return l % r, nil
+		}
+		if l == math.MinInt64 && r == -1 {
+			return overflow()
+		}
+		return l / r, nil
+	case token.STAR_STAR:
+		// exponentiation by squaring, r is not negative
+		result := int64(1)
+		base := l
+		for exponent := r; exponent > 0; exponent >>= 1 {
+			var ok bool
+			if exponent&1 == 1 {
+				if result, ok = multiply(result, base); !ok {
+					return overflow()
+				}
+			}
+			if exponent > 1 {
+				if base, ok = multiply(base, base); !ok {
+					return overflow()
+				}
+			}
+		}
+		return result, nil
+	}
+	return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Unknown operator"}
+}
+
+// bigArithmetic applies an operator to two big integers
+func bigArithmetic(operator token.Token, l, r *big.Int) (interface{}, error) {
+	result := new(big.Int)
+	switch operator.Type {
+	case token.PLUS:
+		result.Add(l, r)
+	case token.MINUS:
+		result.Sub(l, r)
+	case token.STAR:
+		result.Mul(l, r)
+	case token.PERCENT, token.TILDE_SLASH:
+		if r.Sign() == 0 {
+			return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Divide by zero"}
+		}
+		// truncated like the int64 operators
+		if operator.Type == token.PERCENT {
+			result.Rem(l, r)
+		} else {
+			result.Quo(l, r)
+		}
+	case token.STAR_STAR:
+		// r is not negative, 0, 1 and -1 can be raised to any power
+		if l.CmpAbs(big.NewInt(1)) > 0 && (!r.IsInt64() || r.Int64() > maxIntegerBits || int64(l.BitLen()-1)*r.Int64() > maxIntegerBits) {
+			return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Integer too large"}
+		}
+		result.Exp(l, r, nil)
+	default:
+		return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Unknown operator"}
+	}
+	return normalizeInt(result), nil
+}
+
+// multiply reports false if the product overflows
+func multiply(l, r int64) (int64, bool) {
+	if l == 0 || r == 0 {
+		return 0, true
+	}
+	product := l * r
+	if product/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+		return 0, false
+	}
+	return product, true
+}
+
+func floatArithmetic(operator token.Token, l, r float64) (interface{}, error) {
+	divideByZero := &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Divide by zero"}
+	switch operator.Type {
+	case token.PLUS:
+		return l + r, nil
+	case token.MINUS:
+		return l - r, nil
+	case token.STAR:
+		return l * r, nil
+	case token.SLASH:
+		if r == 0 {
+			return nil, divideByZero
+		}
+		return l / r, nil
+	case token.PERCENT:
+		if r == 0 {
+			return nil, divideByZero
+		}
+		return math.Mod(l, r), nil
+	case token.TILDE_SLASH:
+		// truncates towards zero like %, so a == (a ~/ b) * b + a % b
+		if r == 0 {
+			return nil, divideByZero
+		}
+		return math.Trunc(l / r), nil
+	case token.STAR_STAR:
+		if l == 0 && r < 0 {
+			return nil, divideByZero
+		}
+		return math.Pow(l, r), nil
+	}
+	return nil, &RuntimeError{Line: operator.Line, Span: diagnostic.TokenSpan(operator), Where: operator.Lexeme, Message: "Unknown operator"}
+}
+
+// negate returns -value for a number
+func negate(value interface{}) interface{} {
+	switch number := value.(type) {
+	case int64:
+		if number == math.MinInt64 {
+			return new(big.Int).Neg(big.NewInt(number))
+		}
+		return -number
+	case *big.Int:
+		return normalizeInt(new(big.Int).Neg(number))
+	case *Decimal:
+		return &Decimal{unscaled: new(big.Int).Neg(number.unscaled), scale: number.scale}
+	}
+	return -value.(float64)
+}
+
+// compareNumbers returns -1, 0 or 1 as left is less than, equal to or
+// greater than right. Numbers of different types are compared exactly. ok is
+// false if either is NaN.
+func compareNumbers(left, right interface{}) (result int, ok bool) {
+	if l, ok := left.(int64); ok {
+		if r, ok := right.(int64); ok {
+			switch {
+			case l < r:
+				return -1, true
+			case l > r:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	l, lok := toRat(left)
+	r, rok := toRat(right)
+	if !lok || !rok {
+		// NaN or an infinity
+		return compareFloats(toFloat(left), toFloat(right))
+	}
+	return l.Cmp(r), true
+}
+
+func compareFloats(l, r float64) (int, bool) {
+	switch {
+	case l < r:
+		return -1, true
+	case l > r:
+		return 1, true
+	case l == r:
+		return 0, true
+	}
+	return 0, false
+}
+
+// formatNumber formats a number the way print shows it
+func formatNumber(value interface{}) string {
+	switch number := value.(type) {
+	case int64:
+		return strconv.FormatInt(number, 10)
+	case *big.Int:
+		return number.String()
+	case *Decimal:
+		return number.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// toInt implements the int native: floats and decimals are truncated and
+// strings parsed
+func toInt(args []interface{}) (interface{}, error) {
+	switch value := args[0].(type) {
+	case int64, *big.Int:
+		return value, nil
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("Cannot convert %v to int", value)
+		}
+		number, _ := big.NewFloat(math.Trunc(value)).Int(nil)
+		return normalizeInt(number), nil
+	case *Decimal:
+		return normalizeInt(value.integer()), nil
+	case string:
+		number, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("Cannot convert %q to int", value)
+		}
+		return normalizeInt(number), nil
+	}
+	return nil, fmt.Errorf("Cannot convert %v to int", typeName(args[0]))
+}
+
+// toFloatNative implements the float native
+func toFloatNative(args []interface{}) (interface{}, error) {
+	switch value := args[0].(type) {
+	case int64, float64, *big.Int, *Decimal:
+		return toFloat(value), nil
+	case string:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot convert %q to float", value)
+		}
+		return number, nil
+	}
+	return nil, fmt.Errorf("Cannot convert %v to float", typeName(args[0]))
+}
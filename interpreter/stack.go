@@ -0,0 +1,74 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StackFrame is a call that was active when a runtime error happened.
+type StackFrame struct {
+	// Function is the name of the called function, lambda or class
+	Function string
+	// Line is the line of the call site, 0 when called from Go
+	Line int
+}
+
+func (f StackFrame) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("in %v, called from Go", f.Function)
+	}
+	return fmt.Sprintf("in %v, called at line %v", f.Function, f.Line)
+}
+
+// StackTrace lists active calls, outermost first.
+type StackTrace []StackFrame
+
+// how many frames at each end of a long trace are printed
+const traceEdge = 10
+
+// String prints the trace innermost call first, one frame per line. The
+// middle of very deep traces, usually runaway recursion, is elided.
+func (trace StackTrace) String() string {
+	var sb strings.Builder
+	for i := len(trace) - 1; i >= 0; i-- {
+		if len(trace) > 2*traceEdge && i == len(trace)-1-traceEdge {
+			skipped := len(trace) - 2*traceEdge
+			sb.WriteString(fmt.Sprintf("    ... %v more frames\n", skipped))
+			i -= skipped - 1
+			continue
+		}
+		sb.WriteString("    ")
+		sb.WriteString(trace[i].String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// attachTrace records the active calls on a runtime error that does not
+// have a trace yet. It is called while the error unwinds through calls so
+// the innermost call sets it.
+func (interp *Interpreter) attachTrace(err error) {
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok || runtimeErr.Trace != nil {
+		return
+	}
+	runtimeErr.Trace = make(StackTrace, len(interp.frames))
+	copy(runtimeErr.Trace, interp.frames)
+}
+
+func frameName(fn callable) string {
+	switch f := fn.(type) {
+	case *userFunction:
+		return f.declaration.Name.Lexeme
+	case *lambda:
+		return "lambda"
+	case *class:
+		return f.name
+	case *nativeFunction:
+		if f.name == "" {
+			return "native fun"
+		}
+		return f.name
+	}
+	return fn.String()
+}
@@ -0,0 +1,104 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStackTrace(t *testing.T) {
+	input := `
+fun inner(x) {
+	return -x;
+}
+class Wrapper {
+	init(x) {
+		this.value = inner(x);
+	}
+}
+var make = fun (x) { return Wrapper(x); };
+make("a");
+`
+	err := runSource(New(nil), input)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("Expected a runtime error, got %v", err)
+	}
+	if runtimeErr.Line != 3 || runtimeErr.Message != "Operand must be a number" {
+		t.Errorf("Expected error on line 3, got %v", runtimeErr)
+	}
+	if span := input[runtimeErr.Span.Start.Offset:runtimeErr.Span.End.Offset]; span != "-" {
+		t.Errorf("Expected the error to span the operator, got %q", span)
+	}
+	expected := StackTrace{
+		{Function: "lambda", Line: 11},
+		{Function: "Wrapper", Line: 10},
+		{Function: "inner", Line: 7},
+	}
+	if !reflect.DeepEqual(runtimeErr.Trace, expected) {
+		t.Errorf("Expected trace %v got %v instead", expected, runtimeErr.Trace)
+	}
+	printed := "    in inner, called at line 7\n    in Wrapper, called at line 10\n    in lambda, called at line 11\n"
+	if runtimeErr.Trace.String() != printed {
+		t.Errorf("Expected trace to print as:\n%v\nGot:\n%v", printed, runtimeErr.Trace.String())
+	}
+}
+
+func TestStackTraceFromGo(t *testing.T) {
+	interp := runTest(`fun fail() { return 1 + null; }`, nil, t)
+	_, err := interp.Call("fail")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("Expected a runtime error, got %v", err)
+	}
+	if len(runtimeErr.Trace) != 1 || runtimeErr.Trace[0].String() != "in fail, called from Go" {
+		t.Errorf("Expected a single frame called from Go, got %v", runtimeErr.Trace)
+	}
+}
+
+func TestStackTraceElided(t *testing.T) {
+	err := runSource(New(&Options{MaxCallDepth: 100}), `fun r() { r(); } r();`)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("Expected a runtime error, got %v", err)
+	}
+	if len(runtimeErr.Trace) != 100 {
+		t.Errorf("Expected 100 frames got %v instead", len(runtimeErr.Trace))
+	}
+	printed := runtimeErr.Trace.String()
+	if strings.Count(printed, "\n") != 2*traceEdge+1 || !strings.Contains(printed, "... 80 more frames") {
+		t.Errorf("Expected the middle of the trace to be elided, got:\n%v", printed)
+	}
+}
+
+func TestInterpretSource(t *testing.T) {
+	interp := New(nil)
+	sources := []string{
+		"fun fail(x) { return -x; }",
+		"var a = 1;",
+		"fail(\"a\");",
+	}
+	var err error
+	for i, source := range sources {
+		statements, res, compileErr := compile(source)
+		if compileErr != nil {
+			t.Fatal(compileErr)
+		}
+		err = interp.InterpretSource(context.Background(), "line "+strconv.Itoa(i+1), statements, res)
+	}
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.File != "line 1" {
+		t.Fatalf("Expected the error to point at line 1 got %v instead", err)
+	}
+	if span := sources[0][runtimeErr.Span.Start.Offset:runtimeErr.Span.End.Offset]; span != "-" {
+		t.Errorf("Expected the error to span the operator, got %q", span)
+	}
+	statements, res, _ := compile("-\"b\";")
+	err = interp.InterpretSource(context.Background(), "line 4", statements, res)
+	if !errors.As(err, &runtimeErr) || runtimeErr.File != "line 4" {
+		t.Errorf("Expected the error to point at line 4 got %v instead", err)
+	}
+}
@@ -0,0 +1,270 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/token"
+)
+
+// Task is a call started with spawn. It runs on its own goroutine with its
+// own call stack, concurrently with the task that spawned it.
+type Task struct {
+	name string
+	s *scheduler // of the interpreter that spawned it
+	// the fields below are guarded by s.mu
+	finished bool
+	value interface{}
+	err error
+	waited bool // someone received the result
+	waiters []*waiter // tasks waiting for this one to finish
+}
+
+// tasks tracks the tasks spawned during the outermost run
+type tasks struct {
+	wg sync.WaitGroup
+	failed []*Task // in the order they failed, guarded by scheduler.mu
+	cancel context.CancelFunc // cancels the context of the run
+	generators map[*Generator]bool // started and not finished, guarded by scheduler.mu
+}
+
+// scheduler finds deadlocks the way the Go runtime does: once every task of
+// an interpreter is waiting on a channel or on another task, none of them can
+// ever continue. Each interpreter has its own, so tasks and channels can only
+// be used by the interpreter that created them. It also guards their state.
+type scheduler struct {
+	mu sync.Mutex
+	running int // tasks that haven't finished, counting the main task of the run
+	waiting map[*waiter]bool
+}
+
+var (
+	errDeadlock = errors.New("Deadlock, every task is waiting on a channel or another task")
+	errForeignTask = errors.New("Task belongs to another interpreter")
+)
+
+// waiter is a task blocked on channels or on another task
+type waiter struct {
+	woken chan struct{} // closed by wake
+	cases []*channelCase // queued on channels
+	// the result of the wait
+	chosen int
+	value interface{}
+	ok bool
+	err error
+}
+
+func newWaiter() *waiter {
+	return &waiter{woken: make(chan struct{})}
+}
+
+// park blocks the task until w is woken or the context of the task is done.
+// s.mu must be held, park releases it.
+func (s *scheduler) park(interp *Interpreter, w *waiter) error {
+	s.waiting[w] = true
+	s.checkDeadlock()
+	s.mu.Unlock()
+	select {
+	case <-w.woken:
+	case <-interp.ctx.Done():
+		s.mu.Lock()
+		// w may have been woken meanwhile, then the result counts
+		cancelled := s.waiting[w]
+		s.wake(w)
+		s.mu.Unlock()
+		if cancelled {
+			return &CancelledError{Err: interp.ctx.Err()}
+		}
+	}
+	if w.err == errDeadlock && interp.ctx.Err() != nil {
+		// the tasks that could have woken w were cancelled
+		return &CancelledError{Err: interp.ctx.Err()}
+	}
+	return nil
+}
+
+// wake ends the wait of w, withdrawing the channel operations it offered.
+// s.mu must be held.
+func (s *scheduler) wake(w *waiter) {
+	if !s.waiting[w] {
+		return
+	}
+	for _, c := range w.cases {
+		c.withdraw()
+	}
+	delete(s.waiting, w)
+	close(w.woken)
+}
+
+// checkDeadlock wakes every waiting task with errDeadlock if no task is left
+// to wake them. s.mu must be held.
+func (s *scheduler) checkDeadlock() {
+	if s.running == 0 || len(s.waiting) < s.running {
+		return
+	}
+	for w := range s.waiting {
+		w.err = errDeadlock
+		s.wake(w)
+	}
+}
+
+// startTask counts a task that starts running
+func (s *scheduler) startTask() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running++
+}
+
+// finishTask stops counting a task, the tasks waiting for the ones still
+// running may have nobody left to wake them. s.mu must be held.
+func (s *scheduler) finishTask() {
+	s.running--
+	s.checkDeadlock()
+}
+
+// waitTasks waits until every task finished and closes the generators left
+// suspended. If the run failed with err the tasks are cancelled first,
+// otherwise the error of the first task that failed without being waited
+// for, or of closing a generator, is returned.
+func (interp *Interpreter) waitTasks(err error) error {
+	t, s := &interp.tasks, &interp.scheduler
+	if err != nil {
+		t.cancel()
+	}
+	// the main task of the run finished
+	s.mu.Lock()
+	s.finishTask()
+	s.mu.Unlock()
+	t.wg.Wait()
+	closeErr := interp.closeGenerators()
+	// finally blocks run by closing may have spawned tasks
+	t.wg.Wait()
+	t.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, task := range t.failed {
+		if err == nil && !task.waited {
+			err = task.err
+		}
+	}
+	t.failed = nil
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closeGenerators closes the generators started during the run that are
+// still suspended, their goroutines would otherwise wait forever. It
+// returns the first error closing them raised.
+func (interp *Interpreter) closeGenerators() error {
+	s := &interp.scheduler
+	// closing runs lox code on behalf of the main task
+	s.startTask()
+	defer func() {
+		s.mu.Lock()
+		s.finishTask()
+		s.mu.Unlock()
+	}()
+	var first error
+	for {
+		s.mu.Lock()
+		var g *Generator
+		for g = range interp.tasks.generators {
+			break
+		}
+		// closing finishes g even when it fails
+		delete(interp.tasks.generators, g)
+		s.mu.Unlock()
+		if g == nil {
+			return first
+		}
+		err := g.close(interp)
+		if first == nil {
+			first = err
+		}
+	}
+}
+
+// spawn calls callee with arguments in a new task. The call is checked
+// right away, its errors are reported by wait.
+func (interp *Interpreter) spawn(callee interface{}, arguments []interface{}, line int, span diagnostic.Span) (*Task, error) {
+	function, err := checkCall(callee, arguments, line, span)
+	if err != nil {
+		return nil, err
+	}
+	child := interp.fork()
+	s := &interp.scheduler
+	task := &Task{name: frameName(function), s: s}
+	interp.tasks.wg.Add(1)
+	s.startTask()
+	go func() {
+		defer interp.tasks.wg.Done()
+		value, err := child.callValue(function, arguments, line, span)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		task.value, task.err, task.finished = value, err, true
+		if err != nil {
+			interp.tasks.failed = append(interp.tasks.failed, task)
+		}
+		for _, w := range task.waiters {
+			s.wake(w)
+		}
+		task.waiters = nil
+		s.finishTask()
+	}()
+	return task, nil
+}
+
+func (t *Task) String() string {
+	return "<task " + t.name + ">"
+}
+
+// wait blocks until the task finished and returns its result, or raises
+// its error in the waiting task
+func (t *Task) wait(interp *Interpreter) (interface{}, error) {
+	if t.s != &interp.scheduler {
+		return nil, errForeignTask
+	}
+	t.s.mu.Lock()
+	if !t.finished {
+		w := newWaiter()
+		t.waiters = append(t.waiters, w)
+		err := t.s.park(interp, w)
+		if err == nil {
+			err = w.err
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.s.mu.Lock()
+	}
+	defer t.s.mu.Unlock()
+	t.waited = true
+	return t.value, t.err
+}
+
+// get returns the native method name bound to the task
+func (t *Task) get(name token.Token) (interface{}, error) {
+	method := func(arity int, fn taskFunc) (interface{}, error) {
+		return &nativeFunction{name: name.Lexeme, arityNum: arity, taskCallable: fn}, nil
+	}
+	switch name.Lexeme {
+	case "wait":
+		return method(0, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			return t.wait(interp)
+		})
+	case "done":
+		return method(0, func(interp *Interpreter, args []interface{}) (interface{}, error) {
+			if t.s != &interp.scheduler {
+				return nil, errForeignTask
+			}
+			t.s.mu.Lock()
+			defer t.s.mu.Unlock()
+			return t.finished, nil
+		})
+	}
+	return nil, &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: "Undefined property \"" + name.Lexeme + "\"."}
+}
@@ -0,0 +1,137 @@
+package interpreter
+
+import (
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/environment"
+)
+
+// prelude is run in the builtins of every interpreter
+const prelude = `
+class Error {
+	init(message) {
+		this.message = message;
+	}
+}
+class StopIteration < Error {
+	init() {
+		super.init("Iteration finished");
+	}
+}
+`
+
+// runPrelude defines the classes written in lox that every module sees
+func (interp *Interpreter) runPrelude() {
+	statements, res, err := compile(prelude)
+	if err != nil {
+		panic(err)
+	}
+	interp.addLocals(res.Locals)
+	defer interp.enterModule(&Module{name: "builtins", globals: interp.builtins})()
+	interp.env = interp.builtins
+	defer func() { interp.env = interp.global }()
+	for _, statement := range statements {
+		err := interp.execute(statement)
+		if err != nil {
+			panic(err)
+		}
+	}
+	errorClass, _ := interp.builtins.Get("Error")
+	interp.errorClass = errorClass.(*class)
+	stopIterationClass, _ := interp.builtins.Get("StopIteration")
+	interp.stopIterationClass = stopIterationClass.(*class)
+}
+
+// isError reports whether value is an instance of Error or a subclass
+func (interp *Interpreter) isError(value interface{}) bool {
+	return interp.instanceOf(value, interp.errorClass)
+}
+
+// instanceOf reports whether value is an instance of klass or a subclass
+func (interp *Interpreter) instanceOf(value interface{}, klass *class) bool {
+	instance, ok := value.(*Instance)
+	if !ok {
+		return false
+	}
+	for c := instance.klass; c != nil; c = c.superClass {
+		if c == klass {
+			return true
+		}
+	}
+	return false
+}
+
+// throw raises value. Error instances get the line and stack of the throw
+// unless they already have them.
+func (interp *Interpreter) throw(stmt *ast.Throw, value interface{}) error {
+	err := &RuntimeError{Line: stmt.Keyword.Line, Span: diagnostic.NodeSpan(stmt), Value: value, Thrown: true}
+	if interp.isError(value) {
+		instance := value.(*Instance)
+		if _, ok := instance.Field("line"); !ok {
+			instance.set("line", int64(err.Line))
+		}
+		if _, ok := instance.Field("stack"); !ok {
+			instance.set("stack", stackList(interp.frames))
+		}
+		message, _ := instance.Field("message")
+		err.Message = "Uncaught " + instance.klass.name + ": " + stringify(message)
+	} else {
+		err.Message = "Uncaught " + stringify(value)
+	}
+	return err
+}
+
+// caught returns the value a catch block receives for err: the thrown value
+// or an Error instance describing an error raised by the interpreter
+func (interp *Interpreter) caught(err *RuntimeError) interface{} {
+	if err.Thrown {
+		return err.Value
+	}
+	trace := err.Trace
+	if trace == nil {
+		// the error didn't unwind through any call
+		trace = interp.frames
+	}
+	instance := newInstance(interp.errorClass)
+	instance.set("message", err.Message)
+	instance.set("line", int64(err.Line))
+	instance.set("stack", stackList(trace))
+	return instance
+}
+
+// stackList converts a trace to a list of strings, innermost call first
+func stackList(trace StackTrace) *List {
+	frames := make([]interface{}, len(trace))
+	for i, frame := range trace {
+		frames[len(trace)-1-i] = frame.String()
+	}
+	return newList(frames)
+}
+
+func (interp *Interpreter) executeTry(stmt *ast.Try) error {
+	loopDepth := interp.loopDepth
+	err := interp.execute(stmt.Body)
+	if runtimeErr, ok := err.(*RuntimeError); ok && stmt.Catch != nil {
+		// the error may have unwound out of loops inside the try block
+		interp.loopDepth = loopDepth
+		interp.breakHit = false
+		interp.continueHit = false
+		env := environment.Local(interp.env)
+		env.Define(stmt.Name.Lexeme, interp.caught(runtimeErr))
+		err = interp.executeBlock(stmt.Catch.Statements, env)
+	}
+	if stmt.Finally == nil {
+		return err
+	}
+	// break and continue are pending until the finally block ran
+	breakHit, continueHit := interp.breakHit, interp.continueHit
+	interp.breakHit, interp.continueHit = false, false
+	finallyErr := interp.execute(stmt.Finally)
+	if finallyErr != nil {
+		return finallyErr
+	}
+	if !interp.breakHit && !interp.continueHit {
+		interp.breakHit, interp.continueHit = breakHit, continueHit
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package interpreter
+
+import (
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/token"
+)
+
+// operators applied by compound assignments and increments
+var updateOperators = map[token.Type]token.Type{
+	token.PLUS_EQUAL: token.PLUS,
+	token.MINUS_EQUAL: token.MINUS,
+	token.STAR_EQUAL: token.STAR,
+	token.SLASH_EQUAL: token.SLASH,
+	token.PERCENT_EQUAL: token.PERCENT,
+	token.PLUS_PLUS: token.PLUS,
+	token.MINUS_MINUS: token.MINUS,
+}
+
+// evaluateUpdate reads the target of a compound assignment or increment,
+// applies the operator and stores the result. The object and index of the
+// target are evaluated once.
+func (interp *Interpreter) evaluateUpdate(n *ast.Update) (interface{}, error) {
+	var read func() (interface{}, error)
+	var write func(value interface{}) error
+	switch target := n.Target.(type) {
+	case *ast.Variable:
+		read = func() (interface{}, error) {
+			return interp.evaluate(target)
+		}
+		write = func(value interface{}) error {
+			return interp.assignVariable(target, target.Name, value)
+		}
+	case *ast.Get:
+		object, err := interp.evaluate(target.Object)
+		if err != nil {
+			return nil, err
+		}
+		read = func() (interface{}, error) {
+			return interp.property(object, target.Name)
+		}
+		write = func(value interface{}) error {
+			return interp.setProperty(object, target.Name, value)
+		}
+	case *ast.Index:
+		object, err := interp.evaluate(target.Object)
+		if err != nil {
+			return nil, err
+		}
+		index, err := interp.evaluate(target.Index)
+		if err != nil {
+			return nil, err
+		}
+		container, ok := object.(indexable)
+		if !ok {
+			return nil, &RuntimeError{Line: target.RightBracket.Line, Span: diagnostic.NodeSpan(target.Object), Message: "Only lists and maps can be indexed."}
+		}
+		fail := func(err error) error {
+			return &RuntimeError{Line: target.RightBracket.Line, Span: diagnostic.NodeSpan(target.Index), Message: err.Error()}
+		}
+		read = func() (interface{}, error) {
+			value, err := container.index(index)
+			if err != nil {
+				return nil, fail(err)
+			}
+			return value, nil
+		}
+		write = func(value interface{}) error {
+			err := container.setIndex(index, value)
+			if err != nil {
+				return fail(err)
+			}
+			return nil
+		}
+	default:
+		return nil, &RuntimeError{Line: n.Operator.Line, Span: diagnostic.TokenSpan(n.Operator), Message: "Invalid assignment target"}
+	}
+	old, err := read()
+	if err != nil {
+		return nil, err
+	}
+	operator := n.Operator
+	operator.Type = updateOperators[n.Operator.Type]
+	var operand interface{} = int64(1)
+	if n.Value != nil {
+		operand, err = interp.evaluate(n.Value)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = checkNumberOperand(n.Operator, old)
+		if err != nil {
+			return nil, err
+		}
+	}
+	value, err := binary(operator, old, operand)
+	if err != nil {
+		return nil, err
+	}
+	err = write(value)
+	if err != nil {
+		return nil, err
+	}
+	if n.Value == nil && !n.Prefix {
+		return old, nil
+	}
+	return value, nil
+}
+
+// assignVariable assigns a variable resolved for expr
+func (interp *Interpreter) assignVariable(expr ast.Expr, name token.Token, value interface{}) error {
+	var err error
+	distance, ok := interp.depth(expr)
+	if ok {
+		err = interp.env.AssignAt(distance, name.Lexeme, value)
+	} else {
+		err = interp.global.Assign(name.Lexeme, value)
+	}
+	if err != nil {
+		return &RuntimeError{Line: name.Line, Span: diagnostic.TokenSpan(name), Message: err.Error()}
+	}
+	return nil
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"errors"
+	"path/filepath"
+	"strings"
 
+	"github.com/singurty/lox/diagnostic"
 	"github.com/singurty/lox/interpreter"
 	"github.com/singurty/lox/parser"
 	"github.com/singurty/lox/scanner"
@@ -14,17 +19,23 @@ import (
 )
 
 func main() {
+	// LOX_PATH lists extra directories to look for imported modules in
+	options := &interpreter.Options{SearchPath: filepath.SplitList(os.Getenv("LOX_PATH"))}
 	if len(os.Args) > 2 {
 		fmt.Printf("Usage: %v [file]\n", os.Args[0])
 	} else if len(os.Args) == 2 {
-		runFile(os.Args[1])
+		options.Path = os.Args[1]
+		runFile(interpreter.New(options), os.Args[1])
 	} else {
-		runPrompt()
+		runPrompt(interpreter.New(options))
 	}
 }
 
-func runPrompt() {
+func runPrompt(interp *interpreter.Interpreter) {
 	reader := bufio.NewReader(os.Stdin)
+	// every line is a source of its own, functions declared on one line
+	// can fail when called from a later one
+	sources := make(map[string]string)
 	for {
 		fmt.Printf(">> ")
 		text, err := reader.ReadString('\n')
@@ -34,41 +45,100 @@ func runPrompt() {
 		} else if err != nil {
 			panic(err)
 		}
-		err = run(text)
+		name := fmt.Sprintf("<stdin %v>", len(sources)+1)
+		sources[name] = text
+		err = run(interp, name, text)
 		if err != nil {
-			fmt.Println(err.Error())
+			printError(err, name, text, sources)
 		}
 	}
 }
 
-func runFile(file string) {
+func runFile(interp *interpreter.Interpreter, file string) {
 	content, err := os.ReadFile(file)
 	if err != nil {
 		panic(err)
 	}
-	err = run(string(content))
+	err = run(interp, "", string(content))
 	if err != nil {
-		fmt.Println(err.Error())
+		printError(err, displayPath(file), string(content), nil)
 	}
 }
 
-func run(source string) error {
+// displayPath returns the path diagnostics show for file, relative to the
+// working directory when it is inside it, so the main script and the
+// modules it imports are shown the same way
+func displayPath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return abs
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return abs
+	}
+	return rel
+}
+
+// printError shows err with the source it refers to. sources holds the
+// text of the sources named by run.
+func printError(err error, file string, source string, sources map[string]string) {
+	var diagnostics diagnostic.List
+	if errors.As(err, &diagnostics) {
+		diagnostics.Render(os.Stdout, file, source)
+		return
+	}
+	var runtimeErr *interpreter.RuntimeError
+	if errors.As(err, &runtimeErr) && runtimeErr.File != "" {
+		// the error happened in an imported module or an earlier source
+		if content, ok := sources[runtimeErr.File]; ok {
+			file, source = runtimeErr.File, content
+		} else if content, readErr := os.ReadFile(runtimeErr.File); readErr == nil {
+			file, source = displayPath(runtimeErr.File), string(content)
+		} else {
+			runtimeErr.Span = diagnostic.Span{}
+		}
+	}
+	if runtimeErr != nil && runtimeErr.Span.Start.IsValid() {
+		runtimeErr.Diagnostic().Render(os.Stdout, file, source)
+		fmt.Print(runtimeErr.Trace)
+		return
+	}
+	fmt.Println(err.Error())
+	if runtimeErr != nil {
+		fmt.Print(runtimeErr.Trace)
+	}
+}
+
+// run runs source, naming it name unless it is the main script
+func run(interp *interpreter.Interpreter, name string, source string) error {
 	scanner := scanner.New(source)
 	tokens := scanner.ScanTokens()
 	if scanner.HadError {
-		return errors.New("scanner error")
+		return scanner.Diagnostics
 	}
 	parser := parser.New(tokens)
 	statements := parser.Parse()
 	if parser.HadError {
-		return errors.New("parser error")
+		return parser.Diagnostics
 	}
 	resolver := resolver.NewResolver()
 	err := resolver.Resolve(statements)
 	if err != nil {
 		return err
 	}
-	err = interpreter.Interpret(statements, resolver)
+	// interrupting a running script stops it instead of the whole process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if name != "" {
+		err = interp.InterpretSource(ctx, name, statements, resolver)
+	} else {
+		err = interp.InterpretContext(ctx, statements, resolver)
+	}
 	if err != nil {
 		return err
 	}
@@ -1,86 +1,135 @@
 package parser
 
 import (
-	"fmt"
-
 //	"github.com/davecgh/go-spew/spew" // to dump structs for debugging
 	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
 	"github.com/singurty/lox/token"
 )
 
 /*
 program        → block* EOF
-declaration    → funDecl | varDecl | statement
-classDecl      → "class" IDENTIFIER ("<" IDENTIFIER)? "(" function* "}"
+declaration    → funDecl | varDecl | importDecl | statement
+classDecl      → "class" IDENTIFIER ("<" IDENTIFIER)? "(" ("class"? method)* "}"
+method         → function | IDENTIFIER block | "set" function
 funDecl        → "fun" function
 function       → IDENTIFIER "(" parameters? ")" block
 parameters     → IDENTIFIER ("," IDENTIFIER )*
 varDecl        → "var" IDENTIFIER ("=" expression)? ";"
-statement      → exprStmt | printStmt | block | forStmt | break | returnStmt
+importDecl     → "import" STRING "as" IDENTIFIER ";"
+statement      → exprStmt | printStmt | block | forStmt | break | returnStmt | throwStmt | tryStmt
 break          → "break" ";"
 forStmt        → "for" "(" (varDecl | exprStmt | ";") expression? ";" expression? ")" statement
+                 | "for" "(" "var" IDENTIFIER "in" expression ")" statement
 whileStmt      → "while" "(" expression ")" statement
 ifStmt         → "if " "(" expression ")" statement ("else" statement)?
 block          → "{" declaration* "}"
 exprStmt       → expression ";"
 printStmt      → "print" expression ";"
 returnStmt     → "return" expression? ";"
+throwStmt      → "throw" expression ";"
+tryStmt        → "try" block ("catch" "(" IDENTIFIER ")" block)? ("finally" block)?
 expression     → assignment
-assignment     → (call ".")? IDENTIFIER "=" assignment | logic_or
+assignment     → "yield" assignment? | (call ".")? IDENTIFIER "=" assignment | call "[" expression "]" "=" assignment
+                 | target ( "+=" | "-=" | "*=" | "/=" | "%=" ) assignment | logic_or
+target         → (call ".")? IDENTIFIER | call "[" expression "]"
 logic_or       → logic_and ("or" logic_and)*
 logic_and      → ternary ("and" ternary)*
 ternary        → equality "?" equality ":" equality
 equality       → comparison ( ( "!=" | "==" ) comparison )*
 comparison     → term ( ( ">" | ">=" | "<" | "<=" ) term )*
 term           → factor ( ( "-" | "+" ) factor )*
-factor         → unary ( ( "/" | "*" ) unary )*
-unary          → ( "!" | "-" ) unary | primary | call
+factor         → unary ( ( "/" | "*" | "%" | "~/" ) unary )*
+unary          → ( "!" | "-" ) unary | ( "++" | "--" ) unary | "spawn" call | power
+power          → postfix ( "**" unary )?
+postfix        → lambda ( "++" | "--" )?
 lambda        → "fun" "(" parameters? ")" block
-call           → primary ( "(" arguments? ")" | "." IDENTIFIER )*
+call           → primary ( "(" arguments? ")" | "." IDENTIFIER | "[" expression "]" )*
 arguments      → expression ("," expression)*
 primary        → NUMBER | STRING | IDENTIFIER | "true" | "false" | "nil" | "(" expression ")"
-                 | "super" "." IDENTIFIER
+                 | "super" "." IDENTIFIER | "[" arguments? "]" | "{" entries? "}"
+                 | (INTERPOLATION expression)+ STRING
+entries        → expression ":" expression ("," expression ":" expression)*
 */
 
 type Parser struct {
 	tokens []token.Token
 	current int
 	HadError bool
+	Diagnostics diagnostic.List
+	yielded bool // the function body being parsed contains yield
 }
 
 func New(tokens []token.Token) Parser {
 	return Parser{tokens: tokens, current: 0}
 }
 
+// parseError unwinds the parser to the enclosing declaration after a syntax
+// error has been reported
+type parseError struct{}
+
 func (p *Parser) Parse() []ast.Stmt {
 	var statements []ast.Stmt
 	for !p.isAtEnd() {
-		statements = append(statements, p.declaration())
+		if statement := p.declaration(); statement != nil {
+			statements = append(statements, statement)
+		}
 	}
 	return statements
 }
 
-func (p *Parser) declaration() ast.Stmt {
+// declaration returns nil if the declaration has a syntax error
+func (p *Parser) declaration() (statement ast.Stmt) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(parseError); !ok {
+				panic(r)
+			}
+			p.synchronize()
+			statement = nil
+		}
+	}()
 	if p.match(token.VAR) {
 		return p.variableDeclaration()
 	}
 	if p.match(token.FUN) {
-		return p.functionDeclaration()
+		keyword := p.previous()
+		function := p.functionDeclaration()
+		function.Keyword = keyword
+		return function
 	}
 	if p.match(token.CLASS) {
 		return p.classDeclaration()
 	}
+	if p.match(token.IMPORT) {
+		return p.importDeclaration()
+	}
 	return p.statement()
 }
 
+// importDeclaration parses an import. "as" is only special here so it can
+// still be used as a name elsewhere.
+func (p *Parser) importDeclaration() *ast.Import {
+	keyword := p.previous()
+	path := p.consume(token.STRING, "Expected module path after \"import\".")
+	if as := p.peek(); as.Type != token.IDENTIFIER || as.Lexeme != "as" {
+		p.handleError(as, "Expected \"as\" after module path.")
+	}
+	p.advance()
+	name := p.consume(token.IDENTIFIER, "Expected module name after \"as\".")
+	semicolon := p.consume(token.SEMICOLON, "Expected \";\" after import.")
+	return &ast.Import{Keyword: keyword, Path: path, Name: name, Semicolon: semicolon}
+}
+
 func (p *Parser) variableDeclaration() *ast.Var {
+	keyword := p.previous()
 	name := p.consume(token.IDENTIFIER, "Expected variable name")
 	var initializer ast.Expr
 	if p.match(token.EQUAL) {
 		initializer = p.expression()
 	}
-	p.consume(token.SEMICOLON, "Expected \";\" after variable declaration")
-	return &ast.Var{Name: name, Initializer: initializer}
+	semicolon := p.consume(token.SEMICOLON, "Expected \";\" after variable declaration")
+	return &ast.Var{Keyword: keyword, Name: name, Initializer: initializer, Semicolon: semicolon}
 }
 
 func (p *Parser) functionDeclaration() *ast.Function {
@@ -96,11 +145,44 @@ func (p *Parser) functionDeclaration() *ast.Function {
 		p.consume(token.COMMA, "Expected \",\" after parameter.")
 	}
 	p.consume(token.LEFT_BRACE, "Expected \"{\" before function body.")
-	body := p.block().Statements
-	return &ast.Function{Name: name, Parameters: parameters, Body: body}
+	body, generator := p.functionBody()
+	return &ast.Function{Name: name, Parameters: parameters, Body: body.Statements, RightBrace: body.RightBrace, Generator: generator}
+}
+
+// functionBody parses a function body after its "{" and reports whether it
+// contains yield, which makes the function a generator
+func (p *Parser) functionBody() (body *ast.Block, generator bool) {
+	enclosing := p.yielded
+	p.yielded = false
+	defer func() { p.yielded = enclosing }()
+	body = p.block()
+	return body, p.yielded
+}
+
+// method parses a method, a getter without a parameter list or a setter
+// declared with "set". "set" is only special before a method name.
+func (p *Parser) method() *ast.Function {
+	if p.check(token.IDENTIFIER) && p.peek().Lexeme == "set" && p.checkNext(token.IDENTIFIER) {
+		keyword := p.advance()
+		setter := p.functionDeclaration()
+		setter.Keyword = keyword
+		setter.Setter = true
+		if len(setter.Parameters) != 1 {
+			p.reportError(setter.Name, diagnostic.SyntaxError, "A setter must have exactly one parameter.")
+		}
+		return setter
+	}
+	if p.check(token.IDENTIFIER) && p.checkNext(token.LEFT_BRACE) {
+		name := p.advance()
+		p.advance()
+		body, generator := p.functionBody()
+		return &ast.Function{Name: name, Parameters: make([]token.Token, 0), Body: body.Statements, RightBrace: body.RightBrace, Getter: true, Generator: generator}
+	}
+	return p.functionDeclaration()
 }
 
 func (p *Parser) classDeclaration() *ast.Class {
+	keyword := p.previous()
 	name := p.consume(token.IDENTIFIER, "Expected class name.")
 	var superClass *ast.Variable
 	if p.match(token.LESS) {
@@ -108,20 +190,30 @@ func (p *Parser) classDeclaration() *ast.Class {
 	}
 	p.consume(token.LEFT_BRACE, "Expected \"{\" after before class body.")
 	methods := make([]*ast.Function, 0)
+	classMethods := make([]*ast.Function, 0)
 	for !p.check(token.RIGHT_BRACE) && !p.isAtEnd() {
-		methods = append(methods, p.functionDeclaration())
+		if p.match(token.CLASS) {
+			keyword := p.previous()
+			method := p.method()
+			method.Keyword = keyword
+			classMethods = append(classMethods, method)
+		} else {
+			methods = append(methods, p.method())
+		}
 	}
-	p.consume(token.RIGHT_BRACE, "Expected \"}\" after class bdoy.")
-	return &ast.Class{Name: name, SuperClass: superClass, Methods: methods}
+	rightBrace := p.consume(token.RIGHT_BRACE, "Expected \"}\" after class bdoy.")
+	return &ast.Class{Keyword: keyword, Name: name, SuperClass: superClass, Methods: methods, ClassMethods: classMethods, RightBrace: rightBrace}
 }
 
 func (p *Parser) statement() ast.Stmt {
 	if p.match(token.PRINT) {
+		keyword := p.previous()
 		expr := p.expression()
-		p.consume(token.SEMICOLON, "Expected \";\" after expression.")
-		return &ast.PrintStmt{Expression: expr}
+		semicolon := p.consume(token.SEMICOLON, "Expected \";\" after expression.")
+		return &ast.PrintStmt{Keyword: keyword, Expression: expr, Semicolon: semicolon}
 	}
 	if p.match(token.IF) {
+		keyword := p.previous()
 		p.consume(token.LEFT_PAREN, "Expected \"(\" after \"if\"")
 		condition := p.expression()
 		p.consume(token.RIGHT_PAREN, "Expected \")\" after if condition")
@@ -130,28 +222,50 @@ func (p *Parser) statement() ast.Stmt {
 		if p.match(token.ELSE) {
 			elseBranch = p.statement()
 		}
-		return &ast.If{Condition: condition, ElseBranch: elseBranch, ThenBranch: thenBranch}
+		return &ast.If{Keyword: keyword, Condition: condition, ElseBranch: elseBranch, ThenBranch: thenBranch}
 	}
 	if p.match(token.LEFT_BRACE) {
 		return p.block()
 	}
+	if p.match(token.THROW) {
+		keyword := p.previous()
+		value := p.expression()
+		semicolon := p.consume(token.SEMICOLON, "Expected \";\" after thrown value.")
+		return &ast.Throw{Keyword: keyword, Value: value, Semicolon: semicolon}
+	}
+	if p.match(token.TRY) {
+		return p.tryStatement()
+	}
 	if p.match(token.BREAK) {
-		p.consume(token.SEMICOLON, "Expected \";\" after \"break\"")
-		return &ast.Break{}
+		keyword := p.previous()
+		semicolon := p.consume(token.SEMICOLON, "Expected \";\" after \"break\"")
+		return &ast.Break{Keyword: keyword, Semicolon: semicolon}
 	}
 	if p.match(token.CONTINUE) {
-		p.consume(token.SEMICOLON, "Expected \";\" after \"continue\"")
-		return &ast.Continue{}
+		keyword := p.previous()
+		semicolon := p.consume(token.SEMICOLON, "Expected \";\" after \"continue\"")
+		return &ast.Continue{Keyword: keyword, Semicolon: semicolon}
 	}
 	if p.match(token.WHILE) {
+		keyword := p.previous()
 		p.consume(token.LEFT_PAREN, "Expected \"(\" after \"while\"")
 		conditon := p.expression()
 		p.consume(token.RIGHT_PAREN, "Expected \")\" after condition")
 		body := p.statement()
-		return &ast.While{Condition: conditon, Body: body}
+		return &ast.While{Keyword: keyword, Condition: conditon, Body: body}
 	}
 	if p.match(token.FOR) {
+		keyword := p.previous()
 		p.consume(token.LEFT_PAREN, "Expected \"(\" after \"for\"")
+		if p.isForIn() {
+			p.advance()
+			name := p.advance()
+			p.advance()
+			iterable := p.expression()
+			p.consume(token.RIGHT_PAREN, "Expected \")\" after iterable")
+			body := p.statement()
+			return &ast.ForIn{Keyword: keyword, Name: name, Iterable: iterable, Body: body}
+		}
 		var initializer ast.Stmt
 		if p.match(token.SEMICOLON) {
 			initializer = nil
@@ -174,7 +288,7 @@ func (p *Parser) statement() ast.Stmt {
 		if condition == nil {
 			condition = &ast.Literal{Value: true}
 		}
-		loop := &ast.For{Body: body, Condition: condition, Initializer: initializer, Increment: increment}
+		loop := &ast.For{Keyword: keyword, Body: body, Condition: condition, Initializer: initializer, Increment: increment}
 		// wrap the loop in a block so that it gets its own scope
 		statements := make([]ast.Stmt, 1)
 		statements[0] = loop
@@ -186,8 +300,8 @@ func (p *Parser) statement() ast.Stmt {
 		if !p.check(token.SEMICOLON) {
 			value = p.expression()
 		}
-		p.consume(token.SEMICOLON, "Expected \";\" after return value")
-		return &ast.Return{Keyword: keyword, Value: value}
+		semicolon := p.consume(token.SEMICOLON, "Expected \";\" after return value")
+		return &ast.Return{Keyword: keyword, Value: value, Semicolon: semicolon}
 	}
 	return p.expressionStatement()
 }
@@ -199,17 +313,21 @@ func (p *Parser) expressionStatement() ast.Stmt {
 		expr := p.expression()
 		return &ast.PrintStmt{Expression: expr}
 	}
-	p.consume(token.SEMICOLON, "Expected \";\" after expression")
-	return &ast.ExprStmt{Expression: expr}
+	semicolon := p.consume(token.SEMICOLON, "Expected \";\" after expression")
+	return &ast.ExprStmt{Expression: expr, Semicolon: semicolon}
 }
 
+// block expects the "{" to be consumed already
 func (p *Parser) block() *ast.Block {
+	leftBrace := p.previous()
 	var statements []ast.Stmt
 	for !p.check(token.RIGHT_BRACE) && !p.isAtEnd() {
-		statements = append(statements, p.declaration())
+		if statement := p.declaration(); statement != nil {
+			statements = append(statements, statement)
+		}
 	}
-	p.consume(token.RIGHT_BRACE, "Expected \"}\" after block")
-	return &ast.Block{Statements: statements}
+	rightBrace := p.consume(token.RIGHT_BRACE, "Expected \"}\" after block")
+	return &ast.Block{LeftBrace: leftBrace, Statements: statements, RightBrace: rightBrace}
 }
 
 func (p *Parser) expression() ast.Expr {
@@ -217,6 +335,9 @@ func (p *Parser) expression() ast.Expr {
 }
 
 func (p *Parser) assignment() ast.Expr {
+	if p.match(token.YIELD) {
+		return p.yield()
+	}
 	expr := p.or()
 	if p.match(token.EQUAL) {
 		equals := p.previous()
@@ -226,12 +347,40 @@ func (p *Parser) assignment() ast.Expr {
 			return &ast.Assign{Name: e.Name, Value: value}
 		case *ast.Get:
 			return &ast.Set{Name: e.Name, Object: e.Object, Value: value}
+		case *ast.Index:
+			return &ast.SetIndex{Object: e.Object, Index: e.Index, RightBracket: e.RightBracket, Value: value}
 		}
-		p.handleError(equals, "Invalid assignment target")
+		p.reportError(equals, diagnostic.InvalidAssignment, "Invalid assignment target")
+	} else if p.match(token.PLUS_EQUAL, token.MINUS_EQUAL, token.STAR_EQUAL, token.SLASH_EQUAL, token.PERCENT_EQUAL) {
+		operator := p.previous()
+		value := p.assignment()
+		if isUpdateTarget(expr) {
+			return &ast.Update{Target: expr, Operator: operator, Value: value}
+		}
+		p.reportError(operator, diagnostic.InvalidAssignment, "Invalid assignment target")
 	}
 	return expr
 }
 
+// yield parses a yield after its keyword. The value is optional when the
+// yield ends an expression.
+func (p *Parser) yield() *ast.Yield {
+	keyword := p.previous()
+	p.yielded = true
+	if p.check(token.SEMICOLON) || p.check(token.RIGHT_PAREN) || p.check(token.RIGHT_BRACKET) || p.check(token.RIGHT_BRACE) || p.check(token.COMMA) || p.check(token.COLON) {
+		return &ast.Yield{Keyword: keyword}
+	}
+	return &ast.Yield{Keyword: keyword, Value: p.assignment()}
+}
+
+func isUpdateTarget(expr ast.Expr) bool {
+	switch expr.(type) {
+	case *ast.Variable, *ast.Get, *ast.Index:
+		return true
+	}
+	return false
+}
+
 func (p *Parser) or() ast.Expr {
 	expr := p.and()
 	if p.match(token.OR) {
@@ -298,7 +447,7 @@ func (p *Parser) term() ast.Expr {
 
 func (p *Parser) factor() ast.Expr {
 	expr := p.unary()
-	for p.match(token.SLASH, token.STAR) {
+	for p.match(token.SLASH, token.STAR, token.PERCENT, token.TILDE_SLASH) {
 		operator := p.previous()
 		right := p.unary()
 		expr = &ast.Binary{Left: expr, Operator: operator, Right: right}
@@ -313,11 +462,61 @@ func (p *Parser) unary() ast.Expr {
 		expr := &ast.Unary{Operator: operator, Right: right}
 		return expr
 	}
-	return p.lambda()
+	if p.match(token.PLUS_PLUS, token.MINUS_MINUS) {
+		operator := p.previous()
+		target := p.unary()
+		if !isUpdateTarget(target) {
+			p.reportError(operator, diagnostic.InvalidAssignment, "Invalid increment target")
+		}
+		return &ast.Update{Target: target, Operator: operator, Prefix: true}
+	}
+	if p.match(token.SPAWN) {
+		keyword := p.previous()
+		// only the first call is spawned, what follows applies to the task
+		// so spawn f().wait() waits for it
+		callee := p.primary()
+		for {
+			accessed, ok := p.access(callee)
+			if !ok {
+				break
+			}
+			callee = accessed
+		}
+		if !p.match(token.LEFT_PAREN) {
+			p.handleError(keyword, "Expected a call after \"spawn\".")
+		}
+		return p.callChain(&ast.Spawn{Keyword: keyword, Call: p.finishCall(callee)})
+	}
+	return p.power()
+}
+
+// power binds tighter than a unary operator on its left but not on its
+// right, so -2 ** 2 is -4 and 2 ** -1 is 0.5. It is right-associative.
+func (p *Parser) power() ast.Expr {
+	expr := p.postfix()
+	if p.match(token.STAR_STAR) {
+		operator := p.previous()
+		right := p.unary()
+		expr = &ast.Binary{Left: expr, Operator: operator, Right: right}
+	}
+	return expr
+}
+
+func (p *Parser) postfix() ast.Expr {
+	expr := p.lambda()
+	if p.match(token.PLUS_PLUS, token.MINUS_MINUS) {
+		operator := p.previous()
+		if !isUpdateTarget(expr) {
+			p.reportError(operator, diagnostic.InvalidAssignment, "Invalid increment target")
+		}
+		return &ast.Update{Target: expr, Operator: operator}
+	}
+	return expr
 }
 
 func (p *Parser) lambda() ast.Expr {
 	if p.match(token.FUN) {
+		keyword := p.previous()
 		p.consume(token.LEFT_PAREN, "Expected \"(\" after \"fun\"")
 		parameters := make([]token.Token, 0)
 		for !p.match(token.RIGHT_PAREN) && !p.isAtEnd() {
@@ -329,21 +528,24 @@ func (p *Parser) lambda() ast.Expr {
 			p.consume(token.COMMA, "Expected \",\" after parameter")
 		}
 		p.consume(token.LEFT_BRACE, "Expected \"{\" before function body")
-		body := p.block().Statements
-		expr := &ast.Lambda{Parameters: parameters, Body: body}
+		body, generator := p.functionBody()
+		expr := &ast.Lambda{Keyword: keyword, Parameters: parameters, Body: body.Statements, RightBrace: body.RightBrace, Generator: generator}
 		return expr
 	}
 	return p.call()
 }
 
 func (p *Parser) call() ast.Expr {
-	expr := p.primary()
+	return p.callChain(p.primary())
+}
+
+// callChain parses the calls, property accesses and subscripts following expr
+func (p *Parser) callChain(expr ast.Expr) ast.Expr {
 	for {
 		if p.match(token.LEFT_PAREN){
 			expr = p.finishCall(expr)
-		} else if p.match(token.DOT) {
-			name := p.consume(token.IDENTIFIER, "Expected property name after \".\".")
-			expr = &ast.Get{Object: expr, Name: name}
+		} else if accessed, ok := p.access(expr); ok {
+			expr = accessed
 		} else {
 			break
 		}
@@ -351,6 +553,21 @@ func (p *Parser) call() ast.Expr {
 	return expr
 }
 
+// access parses a property access or subscript of expr, ok is false when
+// none follows
+func (p *Parser) access(expr ast.Expr) (accessed ast.Expr, ok bool) {
+	if p.match(token.DOT) {
+		name := p.consume(token.IDENTIFIER, "Expected property name after \".\".")
+		return &ast.Get{Object: expr, Name: name}, true
+	}
+	if p.match(token.LEFT_BRACKET) {
+		index := p.expression()
+		rightBracket := p.consume(token.RIGHT_BRACKET, "Expected \"]\" after index.")
+		return &ast.Index{Object: expr, Index: index, RightBracket: rightBracket}, true
+	}
+	return expr, false
+}
+
 func (p *Parser) finishCall(callee ast.Expr) *ast.Call {
 	arguments := make([]ast.Expr, 0)
 	for !p.check(token.RIGHT_PAREN) && !p.isAtEnd() {
@@ -362,7 +579,7 @@ func (p *Parser) finishCall(callee ast.Expr) *ast.Call {
 		}
 	}
 	if len(arguments) > 255 {
-		p.reportError(p.peek(), "Can't have more than 255 arguments")
+		p.reportError(p.peek(), diagnostic.TooManyArguments, "Can't have more than 255 arguments")
 	}
 	paren := p.consume(token.RIGHT_PAREN, "Expect \")\" after arguments")
 	return &ast.Call{Callee: callee, Paren: paren, Arguments: arguments}
@@ -370,28 +587,69 @@ func (p *Parser) finishCall(callee ast.Expr) *ast.Call {
 
 func (p *Parser) primary() ast.Expr {
 	if p.match(token.FALSE) {
-		return &ast.Literal{Value: false}
+		return &ast.Literal{Token: p.previous(), Value: false}
 	}
 	if p.match(token.TRUE) {
-		return &ast.Literal{Value: true}
+		return &ast.Literal{Token: p.previous(), Value: true}
 	}
 	if p.match(token.NULL) {
-		return &ast.Literal{Value: nil}
+		return &ast.Literal{Token: p.previous(), Value: nil}
 	}
 	if p.match(token.NUMBER, token.STRING) {
-		return &ast.Literal{Value: p.previous().Literal}
+		return &ast.Literal{Token: p.previous(), Value: p.previous().Literal}
+	}
+	if p.match(token.INTERPOLATION) {
+		parts := make([]ast.Expr, 0)
+		for {
+			parts = append(parts, &ast.Literal{Token: p.previous(), Value: p.previous().Literal})
+			parts = append(parts, p.expression())
+			if !p.match(token.INTERPOLATION) {
+				break
+			}
+		}
+		end := p.consume(token.STRING, "Expected \"}\" after interpolated expression.")
+		parts = append(parts, &ast.Literal{Token: end, Value: end.Literal})
+		return &ast.Interpolation{Parts: parts}
 	}
 	if p.match(token.IDENTIFIER) {
 		return &ast.Variable{Name:p.previous()}
 	}
 	if p.match(token.LEFT_PAREN) {
+		leftParen := p.previous()
 		expr := p.expression()
-		p.consume(token.RIGHT_PAREN, "Expected ')' after expression.")
-		return &ast.Grouping{Expression: expr}
+		rightParen := p.consume(token.RIGHT_PAREN, "Expected ')' after expression.")
+		return &ast.Grouping{LeftParen: leftParen, Expression: expr, RightParen: rightParen}
 	}
 	if p.match(token.THIS) {
 		return &ast.This{Keyword: p.previous()}
 	}
+	if p.match(token.LEFT_BRACKET) {
+		leftBracket := p.previous()
+		elements := make([]ast.Expr, 0)
+		for !p.check(token.RIGHT_BRACKET) && !p.isAtEnd() {
+			elements = append(elements, p.expression())
+			if !p.match(token.COMMA) {
+				break
+			}
+		}
+		rightBracket := p.consume(token.RIGHT_BRACKET, "Expected \"]\" after list elements.")
+		return &ast.List{LeftBracket: leftBracket, Elements: elements, RightBracket: rightBracket}
+	}
+	if p.match(token.LEFT_BRACE) {
+		leftBrace := p.previous()
+		keys := make([]ast.Expr, 0)
+		values := make([]ast.Expr, 0)
+		for !p.check(token.RIGHT_BRACE) && !p.isAtEnd() {
+			keys = append(keys, p.expression())
+			p.consume(token.COLON, "Expected \":\" after map key.")
+			values = append(values, p.expression())
+			if !p.match(token.COMMA) {
+				break
+			}
+		}
+		rightBrace := p.consume(token.RIGHT_BRACE, "Expected \"}\" after map entries.")
+		return &ast.Map{LeftBrace: leftBrace, Keys: keys, Values: values, RightBrace: rightBrace}
+	}
 	if p.match(token.SUPER) {
 		keyword := p.previous()
 		p.consume(token.DOT, "Expected \".\" after \"super\".")
@@ -402,6 +660,27 @@ func (p *Parser) primary() ast.Expr {
 	return nil
 }
 
+func (p *Parser) tryStatement() *ast.Try {
+	stmt := &ast.Try{Keyword: p.previous()}
+	p.consume(token.LEFT_BRACE, "Expected \"{\" after \"try\".")
+	stmt.Body = p.block()
+	if p.match(token.CATCH) {
+		p.consume(token.LEFT_PAREN, "Expected \"(\" after \"catch\".")
+		stmt.Name = p.consume(token.IDENTIFIER, "Expected error name.")
+		p.consume(token.RIGHT_PAREN, "Expected \")\" after error name.")
+		p.consume(token.LEFT_BRACE, "Expected \"{\" before catch body.")
+		stmt.Catch = p.block()
+	}
+	if p.match(token.FINALLY) {
+		p.consume(token.LEFT_BRACE, "Expected \"{\" after \"finally\".")
+		stmt.Finally = p.block()
+	}
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.handleError(p.peek(), "Expected \"catch\" or \"finally\" after try block.")
+	}
+	return stmt
+}
+
 func (p *Parser) synchronize() {
 	p.advance()
 
@@ -410,14 +689,7 @@ func (p *Parser) synchronize() {
 			return
 		}
 		switch(p.peek().Type) {
-		case token.CLASS:
-		case token.FUN:
-		case token.VAR:
-		case token.FOR:
-		case token.IF:
-		case token.WHILE:
-		case token.PRINT:
-		case token.RETURN:
+		case token.CLASS, token.FUN, token.VAR, token.FOR, token.IF, token.WHILE, token.PRINT, token.RETURN, token.IMPORT, token.THROW, token.TRY:
 			return
 		}
 		p.advance()
@@ -425,17 +697,13 @@ func (p *Parser) synchronize() {
 }
 
 func (p *Parser) handleError(tk token.Token, message string) {
-	p.reportError(tk, message)
-	p.synchronize()
+	p.reportError(tk, diagnostic.SyntaxError, message)
+	panic(parseError{})
 }
 
-func (p *Parser) reportError(tk token.Token, message string) {
+func (p *Parser) reportError(tk token.Token, code string, message string) {
 	p.HadError = true
-	if tk.Type == token.EOF {
-		fmt.Printf("[Line %v] Error at end: %v\n", tk.Line, message)
-	} else {
-		fmt.Printf("[Line %v] Error at \"%v\": %v\n", tk.Line, tk.Lexeme, message)
-	}
+	p.Diagnostics = append(p.Diagnostics, diagnostic.New(code, diagnostic.TokenSpan(tk), message))
 }
 
 func (p *Parser) consume(tokenType token.Type, message string) token.Token {
@@ -463,6 +731,23 @@ func (p *Parser) check(tokenType token.Type) bool {
 	return p.peek().Type == tokenType
 }
 
+// isForIn reports whether a for loop starts with "var name in". Like "as",
+// "in" is only special here so it can still be used as a name.
+func (p *Parser) isForIn() bool {
+	if !p.check(token.VAR) || !p.checkNext(token.IDENTIFIER) || p.current+2 >= len(p.tokens) {
+		return false
+	}
+	in := p.tokens[p.current+2]
+	return in.Type == token.IDENTIFIER && in.Lexeme == "in"
+}
+
+func (p *Parser) checkNext(tokenType token.Type) bool {
+	if p.isAtEnd() || p.tokens[p.current + 1].Type == token.EOF {
+		return false
+	}
+	return p.tokens[p.current + 1].Type == tokenType
+}
+
 func (p *Parser) advance() token.Token {
 	if !p.isAtEnd() {
 		p.current++
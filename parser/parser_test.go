@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/scanner"
+)
+
+func TestNodeSpans(t *testing.T) {
+	source := `if (a) {
+  print (1 + b.c);
+} else while (x) x = -y;
+fun f(n) { return n; }
+class A < B { m() {} }
+for (var i = 0; i < 1; i = i + 1) {}`
+	sc := scanner.New(source)
+	p := New(sc.ScanTokens())
+	statements := p.Parse()
+	if p.HadError {
+		t.Fatal("parser error")
+	}
+	span := func(node ast.Node) string {
+		return source[node.Pos().Offset:node.End().Offset]
+	}
+	ifStmt := statements[0].(*ast.If)
+	block := ifStmt.ThenBranch.(*ast.Block)
+	print := block.Statements[0].(*ast.PrintStmt)
+	grouping := print.Expression.(*ast.Grouping)
+	binary := grouping.Expression.(*ast.Binary)
+	while := ifStmt.ElseBranch.(*ast.While)
+	assign := while.Body.(*ast.ExprStmt).Expression.(*ast.Assign)
+	function := statements[1].(*ast.Function)
+	class := statements[2].(*ast.Class)
+	loop := statements[3].(*ast.Block)
+	tests := []struct {
+		node ast.Node
+		expected string
+	}{
+		{ifStmt, source[:len(source)-len("\nfun f(n) { return n; }\nclass A < B { m() {} }\nfor (var i = 0; i < 1; i = i + 1) {}")]},
+		{block, "{\n  print (1 + b.c);\n}"},
+		{print, "print (1 + b.c);"},
+		{grouping, "(1 + b.c)"},
+		{binary, "1 + b.c"},
+		{binary.Right, "b.c"},
+		{while, "while (x) x = -y;"},
+		{assign, "x = -y"},
+		{assign.Value, "-y"},
+		{function, "fun f(n) { return n; }"},
+		{function.Body[0], "return n;"},
+		{class, "class A < B { m() {} }"},
+		{class.Methods[0], "m() {}"},
+		{loop, "for (var i = 0; i < 1; i = i + 1) {}"},
+	}
+	for _, test := range tests {
+		if got := span(test.node); got != test.expected {
+			t.Errorf("Expected span %q got %q instead", test.expected, got)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	source := `print "sum: ${a + b}!";`
+	sc := scanner.New(source)
+	p := New(sc.ScanTokens())
+	statements := p.Parse()
+	if p.HadError {
+		t.Fatal(p.Diagnostics.Error())
+	}
+	interpolation := statements[0].(*ast.PrintStmt).Expression.(*ast.Interpolation)
+	if interpolation.String() != "(interpolate sum:  (+ a b) !)" {
+		t.Errorf("Unexpected tree %v", interpolation)
+	}
+	if got := source[interpolation.Pos().Offset:interpolation.End().Offset]; got != `"sum: ${a + b}!"` {
+		t.Errorf("Expected span to cover the whole string got %q instead", got)
+	}
+
+	sc = scanner.New(`print "${a b}";`)
+	p = New(sc.ScanTokens())
+	p.Parse()
+	if !p.HadError || p.Diagnostics[0].Message != "Expected \"}\" after interpolated expression." {
+		t.Errorf("Expected an error for an unclosed interpolation got %q instead", p.Diagnostics.Error())
+	}
+}
+
+func TestOperatorPrecedence(t *testing.T) {
+	tests := []struct {
+		source string
+		expected string
+	}{
+		{"-2 ** 2;", "-(** 2 2)"},
+		{"2 ** -1;", "(** 2 -1)"},
+		{"2 ** 3 ** 2;", "(** 2 (** 3 2))"},
+		{"1 + 7 % 4 * 2;", "(+ 1 (* (% 7 4) 2))"},
+		{"7 ~/ 2 - 1;", "(- (~/ 7 2) 1)"},
+		{"a++ ** 2;", "(** (a ++) 2)"},
+	}
+	for _, test := range tests {
+		sc := scanner.New(test.source)
+		p := New(sc.ScanTokens())
+		statements := p.Parse()
+		if p.HadError {
+			t.Fatalf("parser error for %q: %v", test.source, p.Diagnostics)
+		}
+		got := statements[0].(*ast.ExprStmt).Expression.String()
+		if got != test.expected {
+			t.Errorf("Expected %v for %q got %v instead", test.expected, test.source, got)
+		}
+	}
+}
+
+func TestForIn(t *testing.T) {
+	source := "for (var item in items.all()) print item;"
+	sc := scanner.New(source)
+	p := New(sc.ScanTokens())
+	statements := p.Parse()
+	if p.HadError {
+		t.Fatalf("parser error: %v", p.Diagnostics)
+	}
+	loop, ok := statements[0].(*ast.ForIn)
+	if !ok {
+		t.Fatalf("Expected a for-in loop got %T instead", statements[0])
+	}
+	if loop.Name.Lexeme != "item" {
+		t.Errorf("Expected loop variable item got %v instead", loop.Name.Lexeme)
+	}
+	if _, ok := loop.Iterable.(*ast.Call); !ok {
+		t.Errorf("Expected a call as the iterable got %T instead", loop.Iterable)
+	}
+	if span := source[loop.Pos().Offset:loop.End().Offset]; span != source {
+		t.Errorf("Expected span %q got %q instead", source, span)
+	}
+}
+
+func TestGeneratorFunctions(t *testing.T) {
+	source := `fun outer() { var inner = fun () { yield; }; return inner; }
+fun gen() { var x = yield 1; print x; }
+class A { items() { yield this; } }`
+	sc := scanner.New(source)
+	p := New(sc.ScanTokens())
+	statements := p.Parse()
+	if p.HadError {
+		t.Fatalf("parser error: %v", p.Diagnostics)
+	}
+	outer := statements[0].(*ast.Function)
+	if outer.Generator {
+		t.Errorf("Expected outer not to be a generator, the yield belongs to its lambda")
+	}
+	inner := outer.Body[0].(*ast.Var).Initializer.(*ast.Lambda)
+	if !inner.Generator {
+		t.Errorf("Expected the lambda to be a generator")
+	}
+	if !statements[1].(*ast.Function).Generator {
+		t.Errorf("Expected gen to be a generator")
+	}
+	if !statements[2].(*ast.Class).Methods[0].Generator {
+		t.Errorf("Expected the method items to be a generator")
+	}
+	if value := statements[1].(*ast.Function).Body[0].(*ast.Var).Initializer; value.String() != "(yield 1)" {
+		t.Errorf("Expected (yield 1) got %v instead", value)
+	}
+}
+
+func TestSpawn(t *testing.T) {
+	source := `spawn worker(jobs, 1);
+spawn "not a call";`
+	sc := scanner.New(source)
+	p := New(sc.ScanTokens())
+	statements := p.Parse()
+	if !p.HadError || len(p.Diagnostics) != 1 {
+		t.Fatalf("Expected one error, got %v", p.Diagnostics)
+	}
+	if message := p.Diagnostics[0].Message; message != "Expected a call after \"spawn\"." {
+		t.Errorf("Unexpected error %q", message)
+	}
+	spawn, ok := statements[0].(*ast.ExprStmt).Expression.(*ast.Spawn)
+	if !ok {
+		t.Fatalf("Expected a spawn got %v instead", statements[0])
+	}
+	if len(spawn.Call.Arguments) != 2 {
+		t.Errorf("Expected 2 arguments got %v instead", len(spawn.Call.Arguments))
+	}
+	if spawn.Pos().Offset != 0 || spawn.End().Offset != 21 {
+		t.Errorf("Expected span 0-21 got %v-%v instead", spawn.Pos().Offset, spawn.End().Offset)
+	}
+}
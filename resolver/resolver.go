@@ -4,6 +4,8 @@ import (
 	"errors"
 
 	"github.com/singurty/lox/ast"
+	"github.com/singurty/lox/diagnostic"
+	"github.com/singurty/lox/token"
 )
 
 // function types enum
@@ -20,10 +22,12 @@ const (
 
 type Resolver struct {
 	stack []map[string]bool
+	declarations []map[string]token.Token // where the names in each scope were declared
 	Locals map[ast.Expr]int
 	currentFunction functionType
 	currentClass classType
 	insideLoop bool
+	Diagnostics diagnostic.List
 }
 
 func NewResolver() *Resolver {
@@ -57,13 +61,38 @@ func (r *Resolver) peek() map[string]bool {
 
 func (r *Resolver) beginScope() {
 	r.push(make(map[string]bool))
+	r.declarations = append(r.declarations, make(map[string]token.Token))
 }
 
 func (r *Resolver) endScope() {
 	r.pop()
+	r.declarations = r.declarations[:len(r.declarations)-1]
 }
 
+// Resolve resolves a program. It reports at most one problem per top-level
+// statement, all of which are collected in Diagnostics and returned as a
+// diagnostic.List.
 func (r *Resolver) Resolve(statements []ast.Stmt) error {
+	for _, statement := range statements {
+		err := r.resolveStmt(statement)
+		if err != nil {
+			d, ok := err.(*diagnostic.Diagnostic)
+			if !ok {
+				d = diagnostic.New(diagnostic.SyntaxError, diagnostic.Span{}, err.Error())
+			}
+			r.Diagnostics = append(r.Diagnostics, d)
+			// an error leaves the state of nested scopes behind
+			r.stack = r.stack[:0]
+			r.declarations = r.declarations[:0]
+			r.currentFunction = NONE
+			r.currentClass = NONE
+			r.insideLoop = false
+		}
+	}
+	return r.Diagnostics.Err()
+}
+
+func (r *Resolver) resolveStmts(statements []ast.Stmt) error {
 	for _, statement := range statements {
 		err := r.resolveStmt(statement)
 		if err != nil {
@@ -75,6 +104,8 @@ func (r *Resolver) Resolve(statements []ast.Stmt) error {
 
 func (r *Resolver) resolveStmt(statement ast.Stmt) error {
 	switch s := statement.(type) {
+	case nil:
+		return nil
 	case *ast.Block:
 		err := r.blockStmt(s)
 		if err != nil {
@@ -85,6 +116,22 @@ func (r *Resolver) resolveStmt(statement ast.Stmt) error {
 		if err != nil {
 			return err
 		}
+	case *ast.Throw:
+		err := r.resolveExpr(s.Value)
+		if err != nil {
+			return err
+		}
+	case *ast.Try:
+		err := r.tryStmt(s)
+		if err != nil {
+			return err
+		}
+	case *ast.Import:
+		err := r.declare(s.Name)
+		if err != nil {
+			return err
+		}
+		r.define(s.Name.Lexeme)
 	case *ast.Function:
 		err := r.functionStmt(s)
 		if err != nil {
@@ -125,13 +172,18 @@ func (r *Resolver) resolveStmt(statement ast.Stmt) error {
 		if err != nil {
 			return err
 		}
+	case *ast.ForIn:
+		err := r.forInStmt(s)
+		if err != nil {
+			return err
+		}
 	case *ast.Break:
-		err := r.breakStmt()
+		err := r.breakStmt(s)
 		if err != nil {
 			return err
 		}
 	case *ast.Continue:
-		err := r.continueStmt()
+		err := r.continueStmt(s)
 		if err != nil {
 			return err
 		}
@@ -143,6 +195,8 @@ func (r *Resolver) resolveStmt(statement ast.Stmt) error {
 
 func (r *Resolver) resolveExpr(expression ast.Expr) error {
 	switch e := expression.(type) {
+	case nil:
+		return nil
 	case *ast.Variable:
 		err := r.variableExpr(e)
 		if err != nil {
@@ -173,6 +227,50 @@ func (r *Resolver) resolveExpr(expression ast.Expr) error {
 		if err != nil {
 			return err
 		}
+	case *ast.List:
+		for _, element := range e.Elements {
+			err := r.resolveExpr(element)
+			if err != nil {
+				return err
+			}
+		}
+	case *ast.Interpolation:
+		for _, part := range e.Parts {
+			err := r.resolveExpr(part)
+			if err != nil {
+				return err
+			}
+		}
+	case *ast.Update:
+		err := r.resolveExpr(e.Target)
+		if err != nil {
+			return err
+		}
+		err = r.resolveExpr(e.Value)
+		if err != nil {
+			return err
+		}
+	case *ast.Map:
+		for i, key := range e.Keys {
+			err := r.resolveExpr(key)
+			if err != nil {
+				return err
+			}
+			err = r.resolveExpr(e.Values[i])
+			if err != nil {
+				return err
+			}
+		}
+	case *ast.Index:
+		err := r.indexExpr(e)
+		if err != nil {
+			return err
+		}
+	case *ast.SetIndex:
+		err := r.setIndexExpr(e)
+		if err != nil {
+			return err
+		}
 	case *ast.Lambda:
 		err := r.lambdaExpr(e)
 		if err != nil {
@@ -208,6 +306,16 @@ func (r *Resolver) resolveExpr(expression ast.Expr) error {
 		if err != nil {
 			return err
 		}
+	case *ast.Yield:
+		err := r.yieldExpr(e)
+		if err != nil {
+			return err
+		}
+	case *ast.Spawn:
+		err := r.callExpr(e.Call)
+		if err != nil {
+			return err
+		}
 	default:
 		return errors.New("unknown expression")
 	}
@@ -216,7 +324,7 @@ func (r *Resolver) resolveExpr(expression ast.Expr) error {
 
 func (r *Resolver) blockStmt(block *ast.Block) error {
 	r.beginScope()
-	err := r.Resolve(block.Statements)
+	err := r.resolveStmts(block.Statements)
 	if err != nil {
 		return err
 	}
@@ -225,7 +333,7 @@ func (r *Resolver) blockStmt(block *ast.Block) error {
 }
 
 func (r *Resolver) varStmt(statement *ast.Var) error {
-	err := r.declare(statement.Name.Lexeme)
+	err := r.declare(statement.Name)
 	if err != nil {
 		return err
 	}
@@ -241,7 +349,7 @@ func (r *Resolver) varStmt(statement *ast.Var) error {
 
 func (r *Resolver) classStmt(class *ast.Class) error {
 	if class.SuperClass != nil && class.SuperClass.Name.Lexeme == class.Name.Lexeme {
-		return errors.New("A class cannot inherit from itself.")
+		return diagnostic.New(diagnostic.SelfInheritance, diagnostic.TokenSpan(class.SuperClass.Name), "A class cannot inherit from itself.")
 	}
 	enclosing := r.currentClass
 	if class.SuperClass != nil {
@@ -253,7 +361,7 @@ func (r *Resolver) classStmt(class *ast.Class) error {
 	}
 	r.beginScope()
 	r.peek()["this"] = true
-	r.declare(class.Name.Lexeme)
+	r.declare(class.Name)
 	r.define(class.Name.Lexeme)
 	if class.SuperClass != nil {
 		r.variableExpr(class.SuperClass)
@@ -270,6 +378,13 @@ func (r *Resolver) classStmt(class *ast.Class) error {
 			return err
 		}
 	}
+	// "this" is the class itself in class methods
+	for _, method := range class.ClassMethods {
+		err := r.resolveFunction(method, METHOD)
+		if err != nil {
+			return err
+		}
+	}
 	r.endScope()
 	if class.SuperClass != nil {
 		r.endScope()
@@ -278,14 +393,19 @@ func (r *Resolver) classStmt(class *ast.Class) error {
 	return nil
 }
 
-func (r *Resolver) declare(name string) error {
+func (r *Resolver) declare(name token.Token) error {
 	if len(r.stack) == 0 {
 		return nil
 	}
-	if _, ok := r.peek()[name]; ok {
-		return errors.New("A variable with the same name already exists in this scope")
+	if _, ok := r.peek()[name.Lexeme]; ok {
+		d := diagnostic.New(diagnostic.Redeclaration, diagnostic.TokenSpan(name), "A variable with the same name already exists in this scope")
+		if previous, ok := r.declarations[len(r.declarations)-1][name.Lexeme]; ok {
+			d.WithNote(diagnostic.TokenSpan(previous), "variable declared here")
+		}
+		return d
 	}
-	r.peek()[name] = false
+	r.peek()[name.Lexeme] = false
+	r.declarations[len(r.declarations)-1][name.Lexeme] = name
 	return nil
 }
 
@@ -299,7 +419,11 @@ func (r *Resolver) define(name string) {
 func (r *Resolver) variableExpr(expr *ast.Variable) error {
 	if len(r.stack) > 0 {
 		if value, ok := r.peek()[expr.Name.Lexeme]; ok && !value {
-			return errors.New("Can't read local variable in its own initializer.")
+			d := diagnostic.New(diagnostic.SelfInitializer, diagnostic.TokenSpan(expr.Name), "Can't read local variable in its own initializer.")
+			if declared, ok := r.declarations[len(r.declarations)-1][expr.Name.Lexeme]; ok {
+				d.WithNote(diagnostic.TokenSpan(declared), "variable declared here")
+			}
+			return d
 		}
 	}
 	return r.resolveLocal(expr, expr.Name.Lexeme)
@@ -335,8 +459,28 @@ func (r *Resolver) setExpr(expr *ast.Set) error {
 	return nil
 }
 
+func (r *Resolver) indexExpr(expr *ast.Index) error {
+	err := r.resolveExpr(expr.Object)
+	if err != nil {
+		return err
+	}
+	return r.resolveExpr(expr.Index)
+}
+
+func (r *Resolver) setIndexExpr(expr *ast.SetIndex) error {
+	err := r.resolveExpr(expr.Object)
+	if err != nil {
+		return err
+	}
+	err = r.resolveExpr(expr.Index)
+	if err != nil {
+		return err
+	}
+	return r.resolveExpr(expr.Value)
+}
+
 func (r *Resolver) functionStmt(stmt *ast.Function) error {
-	err := r.declare(stmt.Name.Lexeme)
+	err := r.declare(stmt.Name)
 	if err != nil {
 		return err
 	}
@@ -347,20 +491,24 @@ func (r *Resolver) functionStmt(stmt *ast.Function) error {
 func (r *Resolver) resolveFunction(function *ast.Function, typeFunction functionType) error {
 	enclosingFunction := r.currentFunction
 	r.currentFunction = typeFunction
+	// loops outside the function can't be broken out of from its body
+	enclosingLoop := r.insideLoop
+	r.insideLoop = false
 	r.beginScope()
 	for _, param := range function.Parameters {
-		err := r.declare(param.Lexeme)
+		err := r.declare(param)
 		if err != nil {
 			return err
 		}
 		r.define(param.Lexeme)
 	}
-	err := r.Resolve(function.Body)
+	err := r.resolveStmts(function.Body)
 	if err != nil {
 		return err
 	}
 	r.endScope()
 	r.currentFunction = enclosingFunction
+	r.insideLoop = enclosingLoop
 	return nil
 }
 
@@ -368,6 +516,31 @@ func (r *Resolver) expressionStmt(stmt *ast.ExprStmt) error {
 	return r.resolveExpr(stmt.Expression)
 }
 
+func (r *Resolver) tryStmt(stmt *ast.Try) error {
+	err := r.blockStmt(stmt.Body)
+	if err != nil {
+		return err
+	}
+	if stmt.Catch != nil {
+		// the error is declared in the scope of the catch body
+		r.beginScope()
+		err := r.declare(stmt.Name)
+		if err != nil {
+			return err
+		}
+		r.define(stmt.Name.Lexeme)
+		err = r.resolveStmts(stmt.Catch.Statements)
+		if err != nil {
+			return err
+		}
+		r.endScope()
+	}
+	if stmt.Finally != nil {
+		return r.blockStmt(stmt.Finally)
+	}
+	return nil
+}
+
 func (r *Resolver) ifStmt(stmt *ast.If) error {
 	err := r.resolveExpr(stmt.Condition)
 	if err != nil {
@@ -389,11 +562,11 @@ func (r *Resolver) printStmt(stmt *ast.PrintStmt) error {
 
 func (r *Resolver) returnStmt(stmt *ast.Return) error {
 	if r.currentFunction == NONE {
-		return errors.New("Cannot return from top-level code.")
+		return diagnostic.New(diagnostic.InvalidReturn, diagnostic.TokenSpan(stmt.Keyword), "Cannot return from top-level code.")
 	}
 	if stmt.Value != nil {
 		if r.currentFunction == INITIALIZER {
-			return errors.New("Cannot return from an initializer.")
+			return diagnostic.New(diagnostic.InvalidReturn, diagnostic.TokenSpan(stmt.Keyword), "Cannot return from an initializer.")
 		}
 		return r.resolveExpr(stmt.Value)
 	}
@@ -438,18 +611,42 @@ func (r *Resolver) forStmt(stmt *ast.For) error {
 	return nil
 }
 
-func (r *Resolver) breakStmt() error {
+// forInStmt resolves the iterable in the enclosing scope and the body in a
+// scope holding the loop variable
+func (r *Resolver) forInStmt(stmt *ast.ForIn) error {
+	err := r.resolveExpr(stmt.Iterable)
+	if err != nil {
+		return err
+	}
+	prevStatus := r.insideLoop
+	r.insideLoop = true
+	r.beginScope()
+	err = r.declare(stmt.Name)
+	if err != nil {
+		return err
+	}
+	r.define(stmt.Name.Lexeme)
+	err = r.resolveStmt(stmt.Body)
+	if err != nil {
+		return err
+	}
+	r.endScope()
+	r.insideLoop = prevStatus
+	return nil
+}
+
+func (r *Resolver) breakStmt(stmt *ast.Break) error {
 	if r.insideLoop {
 		return nil
 	}
-	return errors.New("Cannot break outside of a loop")
+	return diagnostic.New(diagnostic.InvalidBreak, diagnostic.TokenSpan(stmt.Keyword), "Cannot break outside of a loop")
 }
 
-func (r *Resolver) continueStmt() error {
+func (r *Resolver) continueStmt(stmt *ast.Continue) error {
 	if r.insideLoop {
 		return nil
 	}
-	return errors.New("Cannot continue outside of a loop")
+	return diagnostic.New(diagnostic.InvalidContinue, diagnostic.TokenSpan(stmt.Keyword), "Cannot continue outside of a loop")
 }
 
 func (r *Resolver) binaryExpr(expr *ast.Binary) error {
@@ -477,20 +674,23 @@ func (r *Resolver) callExpr(expr *ast.Call) error {
 func (r *Resolver) lambdaExpr(expr *ast.Lambda) error {
 	enclosingFunction := r.currentFunction
 	r.currentFunction = FUNCTION
+	enclosingLoop := r.insideLoop
+	r.insideLoop = false
 	r.beginScope()
 	for _, param := range expr.Parameters {
-		err := r.declare(param.Lexeme)
+		err := r.declare(param)
 		if err != nil {
 			return err
 		}
 		r.define(param.Lexeme)
 	}
-	err := r.Resolve(expr.Body)
+	err := r.resolveStmts(expr.Body)
 	if err != nil {
 		return err
 	}
 	r.endScope()
 	r.currentFunction = enclosingFunction
+	r.insideLoop = enclosingLoop
 	return nil
 }
 
@@ -516,14 +716,27 @@ func (r *Resolver) unaryExpr(expr *ast.Unary) error {
 
 func (r *Resolver) thisExpr(expr *ast.This) error {
 	if r.currentClass == NONE {
-		return errors.New("Cannot use \"this\" outside of a class.")
+		return diagnostic.New(diagnostic.InvalidThis, diagnostic.TokenSpan(expr.Keyword), "Cannot use \"this\" outside of a class.")
 	}
 	return r.resolveLocal(expr, expr.Keyword.Lexeme)
 }
 
+func (r *Resolver) yieldExpr(expr *ast.Yield) error {
+	if r.currentFunction == NONE {
+		return diagnostic.New(diagnostic.InvalidYield, diagnostic.TokenSpan(expr.Keyword), "Cannot yield from top-level code.")
+	}
+	if r.currentFunction == INITIALIZER {
+		return diagnostic.New(diagnostic.InvalidYield, diagnostic.TokenSpan(expr.Keyword), "Cannot yield from an initializer.")
+	}
+	if expr.Value != nil {
+		return r.resolveExpr(expr.Value)
+	}
+	return nil
+}
+
 func (r *Resolver) superExpr(expr *ast.Super) error {
 	if r.currentClass != SUBCLASS {
-		return errors.New("Cannot use \"super\" outside of a subclass.")
+		return diagnostic.New(diagnostic.InvalidSuper, diagnostic.TokenSpan(expr.Keyword), "Cannot use \"super\" outside of a subclass.")
 	}
 	r.resolveLocal(expr, expr.Keyword.Lexeme)
 	return nil
@@ -2,19 +2,30 @@ package scanner
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/singurty/lox/diagnostic"
 	"github.com/singurty/lox/token"
 )
 
-// Scanner transforms the source into tokens
+// Scanner transforms the source into tokens. The source is read as UTF-8:
+// strings and comments may contain any character and identifiers may use
+// Unicode letters.
 type Scanner struct {
 	source string
 	tokens []token.Token
 	start int
 	current int
 	line int
+	lineStart int // offset of the first character of the current line
+	startPosition token.Position // position of the current lexeme
+	interpolations []int // unclosed "{" inside each unfinished string interpolation
 	HadError bool
+	Diagnostics diagnostic.List
 }
 
 // Map keywords to indentifiers
@@ -37,6 +48,13 @@ var keywords = map[string]token.Type {
 	"while":	token.WHILE,
 	"break":	token.BREAK,
 	"continue":	token.CONTINUE,
+	"import":	token.IMPORT,
+	"throw":	token.THROW,
+	"try":		token.TRY,
+	"catch":	token.CATCH,
+	"finally":	token.FINALLY,
+	"yield":	token.YIELD,
+	"spawn":	token.SPAWN,
 }
 
 func New(source string) Scanner {
@@ -48,13 +66,18 @@ func (sc *Scanner) ScanTokens() ([]token.Token) {
 	for !sc.isAtEnd() {
 		// beginning of the next lexeme
 		sc.start = sc.current
+		sc.startPosition = sc.position()
 		sc.scanToken()
 	}
-	sc.tokens = append(sc.tokens, token.Token{Type: token.EOF})
+	if len(sc.interpolations) > 0 {
+		sc.errorAt(sc.position(), diagnostic.UnterminatedString, "Unterminated string interpolation")
+	}
+	end := sc.position()
+	sc.tokens = append(sc.tokens, token.Token{Type: token.EOF, Line: end.Line, Start: end, End: end})
 	return sc.tokens
 }
 
-func (sc *Scanner) scanToken() (byte) {
+func (sc *Scanner) scanToken() (rune) {
 	c := sc.advance()
 	switch (c) {
 		case '(':
@@ -64,11 +87,28 @@ func (sc *Scanner) scanToken() (byte) {
 			sc.addToken(token.RIGHT_PAREN)
 			break
 		case '{':
+			if n := len(sc.interpolations); n > 0 {
+				sc.interpolations[n-1]++
+			}
 			sc.addToken(token.LEFT_BRACE)
 			break
 		case '}':
+			n := len(sc.interpolations)
+			if n > 0 && sc.interpolations[n-1] == 0 {
+				// end of an interpolated expression, the string continues
+				sc.interpolations = sc.interpolations[:n-1]
+				sc.scanString()
+				break
+			}
+			if n > 0 {
+				sc.interpolations[n-1]--
+			}
 			sc.addToken(token.RIGHT_BRACE)
 			break
+		case '[':
+			sc.addToken(token.LEFT_BRACKET)
+		case ']':
+			sc.addToken(token.RIGHT_BRACKET)
 		case ',':
 			sc.addToken(token.COMMA)
 			break
@@ -76,10 +116,22 @@ func (sc *Scanner) scanToken() (byte) {
 			sc.addToken(token.DOT)
 			break
 		case '-':
-			sc.addToken(token.MINUS)
+			if sc.match('-') {
+				sc.addToken(token.MINUS_MINUS)
+			} else if sc.match('=') {
+				sc.addToken(token.MINUS_EQUAL)
+			} else {
+				sc.addToken(token.MINUS)
+			}
 			break
 		case '+':
-			sc.addToken(token.PLUS)
+			if sc.match('+') {
+				sc.addToken(token.PLUS_PLUS)
+			} else if sc.match('=') {
+				sc.addToken(token.PLUS_EQUAL)
+			} else {
+				sc.addToken(token.PLUS)
+			}
 			break
 		case ';':
 			sc.addToken(token.SEMICOLON)
@@ -89,8 +141,26 @@ func (sc *Scanner) scanToken() (byte) {
 		case '?':
 			sc.addToken(token.QUESTION_MARK)
 		case '*':
-			sc.addToken(token.STAR)
+			if sc.match('=') {
+				sc.addToken(token.STAR_EQUAL)
+			} else if sc.match('*') {
+				sc.addToken(token.STAR_STAR)
+			} else {
+				sc.addToken(token.STAR)
+			}
 			break
+		case '%':
+			if sc.match('=') {
+				sc.addToken(token.PERCENT_EQUAL)
+			} else {
+				sc.addToken(token.PERCENT)
+			}
+		case '~':
+			if sc.match('/') {
+				sc.addToken(token.TILDE_SLASH)
+			} else {
+				sc.handleError(diagnostic.UnexpectedCharacter, "Unexpected character: ~")
+			}
 		case '!':
 			if sc.match('=') {
 				sc.addToken(token.BANG_EQUAL)
@@ -127,20 +197,21 @@ func (sc *Scanner) scanToken() (byte) {
 				}
 			} else if sc.match('*') {
 				// block comment goes ultil */
-				for (sc.peek() != '*' && sc.peekNext() != '/' && !sc.isAtEnd()) {
-					if sc.peek() == '\n' {
-						sc.line++
+				for (!(sc.peek() == '*' && sc.peekNext() == '/') && !sc.isAtEnd()) {
+					if sc.advance() == '\n' {
+						sc.newLine()
 					}
-					sc.advance()
 				}
 				// unterminated comment
 				if sc.isAtEnd() {
-					sc.handleError(sc.line, "Unterminated block comment")
+					sc.handleError(diagnostic.UnterminatedComment, "Unterminated block comment")
 				} else {
 					// consume * and /
 					sc.advance()
 					sc.advance()
 				}
+			} else if sc.match('=') {
+				sc.addToken(token.SLASH_EQUAL)
 			} else {
 				sc.addToken(token.SLASH)
 			}
@@ -151,7 +222,7 @@ func (sc *Scanner) scanToken() (byte) {
 			// Ignore whitespace
 			break;
 		case '\n':
-			sc.line++
+			sc.newLine()
 			break
 		case '"':
 			sc.scanString()
@@ -161,8 +232,10 @@ func (sc *Scanner) scanToken() (byte) {
 				sc.scanNumber()
 			} else if isAlpha(c) {
 				sc.scanIdentifier()
+			} else if c == utf8.RuneError && !sc.validRuneBefore() {
+				sc.handleError(diagnostic.InvalidEncoding, "Invalid UTF-8 encoding")
 			} else {
-				sc.handleError(sc.line, fmt.Sprintf("Unexpected character: %c", c))
+				sc.handleError(diagnostic.UnexpectedCharacter, fmt.Sprintf("Unexpected character: %c", c))
 			}
 			break
 	}
@@ -183,6 +256,8 @@ func (sc *Scanner) scanIdentifier() {
 	}
 }
 
+// scanNumber scans an integer literal as int64, or as *big.Int when it is
+// too large, and a literal with a fractional part as float64
 func (sc *Scanner) scanNumber() {
 	for isDigit(sc.peek()) {
 		sc.advance()
@@ -196,93 +271,205 @@ func (sc *Scanner) scanNumber() {
 		for isDigit(sc.peek()) {
 			sc.advance()
 		}
+		number, err := strconv.ParseFloat(sc.source[sc.start:sc.current], 64)
+		if err != nil {
+			sc.handleError(diagnostic.InvalidNumber, "Invalid number")
+		}
+		sc.addTokenWithLiteral(token.NUMBER, number)
+		return
 	}
-	number, err := strconv.ParseFloat(sc.source[sc.start:sc.current], 64)
+	number, err := strconv.ParseInt(sc.source[sc.start:sc.current], 10, 64)
 	if err != nil {
-		sc.handleError(sc.line, "Invalid number")
+		large, _ := new(big.Int).SetString(sc.source[sc.start:sc.current], 10)
+		sc.addTokenWithLiteral(token.NUMBER, large)
+		return
 	}
-	// Parse as float
 	sc.addTokenWithLiteral(token.NUMBER, number)
 }
 
-func (sc * Scanner) scanString() {
+func (sc *Scanner) scanString() {
+	var sb strings.Builder
 	for (sc.peek() != '"' && !sc.isAtEnd()) {
-		if (sc.peek() == '\n') {
-			sc.line++
+		escapeStart := sc.position()
+		c := sc.advance()
+		switch c {
+		case '\n':
+			sc.newLine()
+		case '\\':
+			if sc.isAtEnd() {
+				continue
+			}
+			escaped, ok := sc.scanEscape()
+			if !ok {
+				sc.errorAt(escapeStart, diagnostic.InvalidEscape, "Invalid escape sequence "+sc.source[escapeStart.Offset:sc.current])
+				continue
+			}
+			sb.WriteRune(escaped)
+			continue
+		case '$':
+			if sc.match('{') {
+				// the expression is scanned as ordinary tokens until the
+				// matching "}"
+				sc.interpolations = append(sc.interpolations, 0)
+				sc.addTokenWithLiteral(token.INTERPOLATION, sb.String())
+				return
+			}
+		case utf8.RuneError:
+			if !sc.validRuneBefore() {
+				sc.errorAt(escapeStart, diagnostic.InvalidEncoding, "Invalid UTF-8 encoding in string")
+			}
 		}
-		sc.advance()
+		sb.WriteRune(c)
 	}
 	if sc.isAtEnd() {
-		sc.handleError(sc.line, "Unterminated string")
+		sc.handleError(diagnostic.UnterminatedString, "Unterminated string")
 		return
 	}
 	// The closing "
 	sc.advance()
-	// Trim surrounding quotes
-	value := sc.source[sc.start+1 : sc.current-1]
-	sc.addTokenWithLiteral(token.STRING, value)
+	sc.addTokenWithLiteral(token.STRING, sb.String())
 }
 
-func (sc *Scanner) handleError(line int, message string) {
+// scanEscape reads the escape sequence after a backslash. Supported
+// sequences are \n, \t, \r, \0, \", \\, \$ and \u{XXXX} with 1 to 6 hex
+// digits.
+func (sc *Scanner) scanEscape() (rune, bool) {
+	c := sc.advance()
+	switch c {
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 'r':
+		return '\r', true
+	case '0':
+		return 0, true
+	case '"':
+		return '"', true
+	case '\\':
+		return '\\', true
+	case '$':
+		return '$', true
+	case 'u':
+		if !sc.match('{') {
+			return 0, false
+		}
+		start := sc.current
+		for isHexDigit(sc.peek()) {
+			sc.advance()
+		}
+		digits := sc.source[start:sc.current]
+		if !sc.match('}') || len(digits) == 0 || len(digits) > 6 {
+			return 0, false
+		}
+		code, _ := strconv.ParseUint(digits, 16, 32)
+		if !utf8.ValidRune(rune(code)) {
+			return 0, false
+		}
+		return rune(code), true
+	case '\n':
+		sc.newLine()
+	}
+	return 0, false
+}
+
+// handleError records a problem with the current lexeme
+func (sc *Scanner) handleError(code string, message string) {
+	sc.errorAt(sc.startPosition, code, message)
+}
+
+// errorAt records a problem from start to the current position
+func (sc *Scanner) errorAt(start token.Position, code string, message string) {
 	sc.HadError = true
-	fmt.Printf("[Line %v] Error: %v\n", line, message)
+	span := diagnostic.Span{Start: start, End: sc.position()}
+	sc.Diagnostics = append(sc.Diagnostics, diagnostic.New(code, span, message))
 }
 
-func isDigit(c byte) bool {
-	if _, err := strconv.Atoi(string(c)); err == nil {
-		return true
-	}
-	return false
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
 }
 
-func isAlpha(c byte) bool {
-	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '-'
+func isHexDigit(c rune) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
 }
 
-func isAlphaNumeric(c byte) bool {
-	return isDigit(c) || isAlpha(c)
+func isAlpha(c rune) bool {
+	return c == '_' || unicode.IsLetter(c)
 }
 
-func (sc *Scanner) advance() byte {
-	c := sc.source[sc.current]
-	sc.current++
+func isAlphaNumeric(c rune) bool {
+	return isAlpha(c) || unicode.IsDigit(c)
+}
+
+func (sc *Scanner) advance() rune {
+	c, size := utf8.DecodeRuneInString(sc.source[sc.current:])
+	sc.current += size
 	return c
 }
 
+// validRuneBefore reports whether the character just consumed was a
+// correctly encoded U+FFFD rather than an invalid byte
+func (sc *Scanner) validRuneBefore() bool {
+	_, size := utf8.DecodeLastRuneInString(sc.source[:sc.current])
+	return size > 1
+}
+
 func (sc *Scanner) addToken(tokenType token.Type) {
 	sc.addTokenWithLiteral(tokenType, nil)
 }
 
 func (sc *Scanner) addTokenWithLiteral(tokenType token.Type, literal interface{}) {
 	text := sc.source[sc.start:sc.current]
-	sc.tokens = append(sc.tokens, token.Token{Type:tokenType, Lexeme: text, Literal: literal, Line: sc.line})
+	sc.tokens = append(sc.tokens, token.Token{
+		Type:tokenType,
+		Lexeme: text,
+		Literal: literal,
+		Line: sc.startPosition.Line,
+		Start: sc.startPosition,
+		End: sc.position(),
+	})
+}
+
+// newLine must be called after consuming a '\n'
+func (sc *Scanner) newLine() {
+	sc.line++
+	sc.lineStart = sc.current
+}
+
+// position returns the position of the next character
+func (sc *Scanner) position() token.Position {
+	column := utf8.RuneCountInString(sc.source[sc.lineStart:sc.current]) + 1
+	return token.Position{Offset: sc.current, Line: sc.line, Column: column}
 }
 
 func (sc *Scanner) isAtEnd() bool {
 	return sc.current >= len(sc.source)
 }
 
-func (sc *Scanner) match(expected byte) bool {
-	if sc.isAtEnd() {
-		return false
-	}
-	if sc.source[sc.current] != expected {
+func (sc *Scanner) match(expected rune) bool {
+	if sc.peek() != expected || sc.isAtEnd() {
 		return false
 	}
-	sc.current++
+	sc.advance()
 	return true
 }
 
-func (sc *Scanner) peek() byte {
+func (sc *Scanner) peek() rune {
 	if sc.isAtEnd() {
 		return 0x00
 	}
-	return sc.source[sc.current]
+	c, _ := utf8.DecodeRuneInString(sc.source[sc.current:])
+	return c
 }
 
-func (sc *Scanner) peekNext() byte {
-	if sc.current + 1 >= len(sc.source) {
+func (sc *Scanner) peekNext() rune {
+	if sc.isAtEnd() {
 		return 0x00
 	}
-	return sc.source[sc.current + 1]
+	_, size := utf8.DecodeRuneInString(sc.source[sc.current:])
+	if sc.current + size >= len(sc.source) {
+		return 0x00
+	}
+	c, _ := utf8.DecodeRuneInString(sc.source[sc.current + size:])
+	return c
 }
@@ -0,0 +1,208 @@
+package scanner
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/singurty/lox/token"
+)
+
+func TestTokenPositions(t *testing.T) {
+	source := "var a = 1;\n// comment\n  print \"two\nlines\" + a;"
+	sc := New(source)
+	tokens := sc.ScanTokens()
+	tests := []struct {
+		lexeme string
+		start token.Position
+		end token.Position
+	}{
+		{"var", token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{"a", token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{"=", token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{"1", token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 9, Line: 1, Column: 10}},
+		{";", token.Position{Offset: 9, Line: 1, Column: 10}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{"print", token.Position{Offset: 24, Line: 3, Column: 3}, token.Position{Offset: 29, Line: 3, Column: 8}},
+		{"\"two\nlines\"", token.Position{Offset: 30, Line: 3, Column: 9}, token.Position{Offset: 41, Line: 4, Column: 7}},
+		{"+", token.Position{Offset: 42, Line: 4, Column: 8}, token.Position{Offset: 43, Line: 4, Column: 9}},
+		{"a", token.Position{Offset: 44, Line: 4, Column: 10}, token.Position{Offset: 45, Line: 4, Column: 11}},
+		{";", token.Position{Offset: 45, Line: 4, Column: 11}, token.Position{Offset: 46, Line: 4, Column: 12}},
+		{"", token.Position{Offset: 46, Line: 4, Column: 12}, token.Position{Offset: 46, Line: 4, Column: 12}},
+	}
+	if len(tokens) != len(tests) {
+		t.Fatalf("Expected %v tokens got %v instead", len(tests), len(tokens))
+	}
+	for i, test := range tests {
+		tk := tokens[i]
+		if tk.Lexeme != test.lexeme || tk.Start != test.start || tk.End != test.end {
+			t.Errorf("Expected %q at %+v-%+v got %q at %+v-%+v instead", test.lexeme, test.start, test.end, tk.Lexeme, tk.Start, tk.End)
+		}
+		if tk.Line != tk.Start.Line {
+			t.Errorf("Expected line of %q to be %v got %v instead", tk.Lexeme, tk.Start.Line, tk.Line)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		source string
+		value string
+	}{
+		{`"a\nb"`, "a\nb"},
+		{`"\t\r\0"`, "\t\r\x00"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\u{e9}\u{1F600}"`, "é😀"},
+		{`"café ✓"`, "café ✓"},
+	}
+	for _, test := range tests {
+		sc := New(test.source)
+		tokens := sc.ScanTokens()
+		if sc.HadError {
+			t.Errorf("Unexpected errors for %v: %v", test.source, sc.Diagnostics.Error())
+			continue
+		}
+		if tokens[0].Type != token.STRING || tokens[0].Literal != test.value {
+			t.Errorf("Expected %q to scan to %q got %q instead", test.source, test.value, tokens[0].Literal)
+		}
+		if tokens[0].Lexeme != test.source {
+			t.Errorf("Expected lexeme %v got %v instead", test.source, tokens[0].Lexeme)
+		}
+	}
+}
+
+func TestInvalidStrings(t *testing.T) {
+	tests := []struct {
+		source string
+		message string
+	}{
+		{`"\q"`, `1:2: error[invalid-escape]: Invalid escape sequence \q`},
+		{`"\u{110000}"`, `1:2: error[invalid-escape]: Invalid escape sequence \u{110000}`},
+		{`"\u{}"`, `1:2: error[invalid-escape]: Invalid escape sequence \u{}`},
+		{`"é\u00e9"`, `1:3: error[invalid-escape]: Invalid escape sequence \u`},
+		{"\"a\xffb\"", `1:3: error[invalid-encoding]: Invalid UTF-8 encoding in string`},
+		{`"open\"`, `1:1: error[unterminated-string]: Unterminated string`},
+	}
+	for _, test := range tests {
+		sc := New(test.source)
+		sc.ScanTokens()
+		if !sc.HadError || sc.Diagnostics[0].Error() != test.message {
+			t.Errorf("Expected %q for %v got %q instead", test.message, test.source, sc.Diagnostics.Error())
+		}
+	}
+}
+
+func TestUnicodeSource(t *testing.T) {
+	source := "/* ünïcödé\n*comment* */ var π_2 = \"日本\"; // ✓\nπ_2;"
+	sc := New(source)
+	tokens := sc.ScanTokens()
+	if sc.HadError {
+		t.Fatal(sc.Diagnostics.Error())
+	}
+	expected := []struct {
+		tokenType token.Type
+		lexeme string
+		column int
+	}{
+		{token.VAR, "var", 14},
+		{token.IDENTIFIER, "π_2", 18},
+		{token.EQUAL, "=", 22},
+		{token.STRING, "\"日本\"", 24},
+		{token.SEMICOLON, ";", 28},
+		{token.IDENTIFIER, "π_2", 1},
+		{token.SEMICOLON, ";", 4},
+		{token.EOF, "", 5},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %v tokens got %v instead", len(expected), len(tokens))
+	}
+	for i, test := range expected {
+		tk := tokens[i]
+		if tk.Type != test.tokenType || tk.Lexeme != test.lexeme || tk.Start.Column != test.column {
+			t.Errorf("Expected %q at column %v got %q at column %v instead", test.lexeme, test.column, tk.Lexeme, tk.Start.Column)
+		}
+	}
+
+	// "-" used to be allowed in identifiers
+	sc = New("a-b")
+	tokens = sc.ScanTokens()
+	if len(tokens) != 4 || tokens[0].Lexeme != "a" || tokens[1].Type != token.MINUS || tokens[2].Lexeme != "b" {
+		t.Errorf("Expected a-b to be a subtraction got %v instead", tokens)
+	}
+
+	sc = New("var a = 1 € 2;")
+	sc.ScanTokens()
+	if !sc.HadError || sc.Diagnostics.Error() != "1:11: error[unexpected-character]: Unexpected character: €" {
+		t.Errorf("Expected an unexpected character error got %q instead", sc.Diagnostics.Error())
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	sc := New(`"a${b + {}["c"]}d${"e${f}"}"`)
+	tokens := sc.ScanTokens()
+	if sc.HadError {
+		t.Fatal(sc.Diagnostics.Error())
+	}
+	expected := []struct {
+		tokenType token.Type
+		lexeme string
+	}{
+		{token.INTERPOLATION, `"a${`},
+		{token.IDENTIFIER, "b"},
+		{token.PLUS, "+"},
+		{token.LEFT_BRACE, "{"},
+		{token.RIGHT_BRACE, "}"},
+		{token.LEFT_BRACKET, "["},
+		{token.STRING, `"c"`},
+		{token.RIGHT_BRACKET, "]"},
+		{token.INTERPOLATION, "}d${"},
+		{token.INTERPOLATION, `"e${`},
+		{token.IDENTIFIER, "f"},
+		{token.STRING, `}"`},
+		{token.STRING, `}"`},
+		{token.EOF, ""},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %v tokens got %v instead", len(expected), len(tokens))
+	}
+	for i, test := range expected {
+		if tokens[i].Type != test.tokenType || tokens[i].Lexeme != test.lexeme {
+			t.Errorf("Expected %q got %q instead", test.lexeme, tokens[i].Lexeme)
+		}
+	}
+	if tokens[0].Literal != "a" || tokens[8].Literal != "d" || tokens[12].Literal != "" {
+		t.Errorf("Unexpected string parts %q, %q and %q", tokens[0].Literal, tokens[8].Literal, tokens[12].Literal)
+	}
+
+	sc = New(`"a${b`)
+	sc.ScanTokens()
+	if !sc.HadError || sc.Diagnostics.Error() != "1:6: error[unterminated-string]: Unterminated string interpolation" {
+		t.Errorf("Expected an unterminated interpolation got %q instead", sc.Diagnostics.Error())
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		source string
+		literal interface{}
+	}{
+		{"42", int64(42)},
+		{"9223372036854775807", int64(9223372036854775807)},
+		{"4.5", 4.5},
+		{"1.0", 1.0},
+	}
+	for _, test := range tests {
+		sc := New(test.source)
+		tokens := sc.ScanTokens()
+		if sc.HadError {
+			t.Fatalf("scanner error for %q: %v", test.source, sc.Diagnostics)
+		}
+		if tokens[0].Literal != test.literal {
+			t.Errorf("Expected %#v for %q got %#v instead", test.literal, test.source, tokens[0].Literal)
+		}
+	}
+	sc := New("9223372036854775808")
+	tokens := sc.ScanTokens()
+	if large, ok := tokens[0].Literal.(*big.Int); !ok || large.String() != "9223372036854775808" {
+		t.Errorf("Expected a big integer got %#v instead", tokens[0].Literal)
+	}
+}
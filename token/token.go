@@ -10,6 +10,8 @@ const (
 	RIGHT_PAREN
 	LEFT_BRACE
 	RIGHT_BRACE
+	LEFT_BRACKET
+	RIGHT_BRACKET
 	COMMA
 	DOT
 	MINUS
@@ -17,6 +19,7 @@ const (
 	SEMICOLON
 	SLASH
 	STAR
+	PERCENT
 	QUESTION_MARK
 	COLON
 
@@ -29,10 +32,22 @@ const (
 	GREATER_EQUAL
 	LESS
 	LESS_EQUAL
+	PLUS_EQUAL
+	MINUS_EQUAL
+	STAR_EQUAL
+	SLASH_EQUAL
+	PERCENT_EQUAL
+	PLUS_PLUS
+	MINUS_MINUS
+	STAR_STAR
+	TILDE_SLASH
 
 	// Literals
 	IDENTIFIER
 	STRING
+	// INTERPOLATION is the part of a string before an interpolated
+	// expression, up to and including "${"
+	INTERPOLATION
 	NUMBER
 
 	// Keywords
@@ -54,15 +69,44 @@ const (
 	WHILE
 	BREAK
 	CONTINUE
+	IMPORT
+	THROW
+	TRY
+	CATCH
+	FINALLY
+	YIELD
+	SPAWN
 
 	EOF
 )
 
+// Position is a location in the source. Offset counts bytes from the start
+// of the source, Column counts characters (not bytes) from the start of the
+// line, and Line and Column start at 1. The zero Position is unknown.
+type Position struct {
+	Offset int `json:"offset"`
+	Line int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%v:%v", p.Line, p.Column)
+}
+
+// IsValid reports whether the position is known
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
 type Token struct {
 	Type Type
 	Lexeme string
 	Literal interface{}
 	Line int
+	// Start is the position of the first character of the token and End the
+	// position just after its last one
+	Start Position
+	End Position
 }
 
 func (token *Token) String() string {